@@ -0,0 +1,201 @@
+// cmd/beatportdl/download.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/unspok3n/beatportdl-ui/internal/beatport"
+	"github.com/unspok3n/beatportdl-ui/internal/pipeline"
+)
+
+const (
+	// collectionPageSize is the number of items requested per page while
+	// expanding collections
+	collectionPageSize = 100
+
+	// incompleteDirectory holds partial downloads inside the downloads
+	// directory, named after the track so the next run resumes them
+	incompleteDirectory = ".incomplete"
+)
+
+// task is a single track to download
+type task struct {
+	link  *beatport.Link
+	label string
+	// directory is relative to the downloads directory, empty for tracks
+	// given directly
+	directory string
+}
+
+type summary struct {
+	downloaded int
+	skipped    int
+	failed     int
+}
+
+// run downloads every track behind urls. Collections are expanded one
+// after another while the workers download the tracks found so far.
+func (a *app) run(ctx context.Context, urls []string) summary {
+	var s summary
+	var mu sync.Mutex
+	tasks := make(chan task)
+
+	var wg sync.WaitGroup
+	for range a.cfg.Downloads.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range tasks {
+				skipped, err := a.download(ctx, t)
+				if ctx.Err() != nil {
+					continue
+				}
+				mu.Lock()
+				switch {
+				case err != nil:
+					s.failed++
+				case skipped:
+					s.skipped++
+				default:
+					s.downloaded++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, u := range urls {
+		if ctx.Err() != nil {
+			break
+		}
+		found, err := a.expand(ctx, u)
+		if err != nil {
+			if ctx.Err() == nil {
+				a.board.Printf("Failed %s: %v", u, err)
+				mu.Lock()
+				s.failed++
+				mu.Unlock()
+			}
+			continue
+		}
+		for _, t := range found {
+			select {
+			case tasks <- t:
+			case <-ctx.Done():
+			}
+		}
+	}
+	close(tasks)
+	wg.Wait()
+	return s
+}
+
+// expand turns a URL into the tracks to download
+func (a *app) expand(ctx context.Context, u string) ([]task, error) {
+	link, err := beatport.ParseUrl(u)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	if link.Type == beatport.TrackLink {
+		return []task{{link: link, label: fmt.Sprintf("Track %d", link.ID)}}, nil
+	}
+
+	opts := beatport.PageOptions{PerPage: collectionPageSize}
+	directory, tracks, err := a.client(link.Store).Expand(ctx, link, a.cfg.DirectoryNaming, opts)
+	if err != nil {
+		return nil, fmt.Errorf("error expanding %s: %w", link.Type, err)
+	}
+	a.board.Printf("Found %d track(s) in %s %s", len(tracks), link.Type, directory)
+
+	found := make([]task, 0, len(tracks))
+	for _, t := range tracks {
+		found = append(found, task{
+			link: &beatport.Link{
+				Original: t.Track.StoreUrl(),
+				Type:     beatport.TrackLink,
+				ID:       t.Track.ID,
+				Store:    link.Store,
+			},
+			label:     trackLabel(&t.Track),
+			directory: t.Directory,
+		})
+	}
+	return found, nil
+}
+
+func trackLabel(track *beatport.Track) string {
+	return fmt.Sprintf("%s - %s", track.Artists.Display(0, ""), track.Title())
+}
+
+// download fetches a track, retrying failures that may go away as
+// configured for the server. It reports whether the track was skipped
+// because it already exists.
+func (a *app) download(ctx context.Context, t task) (bool, error) {
+	bar := a.board.Add(t.label)
+	retry := a.cfg.Downloads.Retry
+	for attempt := 1; ; attempt++ {
+		path, err := a.fetch(ctx, t, bar)
+		switch {
+		case err == nil && path == "":
+			return true, nil
+		case err == nil:
+			bar.Done("Downloaded %s", path)
+			return false, nil
+		case ctx.Err() != nil:
+			bar.Done("Cancelled %s", bar.label)
+			return false, err
+		case !pipeline.Retryable(err) || attempt >= retry.MaxAttempts:
+			bar.Done("Failed %s: %v", bar.label, err)
+			return false, err
+		}
+
+		delay := a.cfg.RetryDelay(attempt)
+		bar.SetStatus(fmt.Sprintf("retry in %s", delay.Round(time.Second)))
+		select {
+		case <-ctx.Done():
+			bar.Done("Cancelled %s", bar.label)
+			return false, context.Cause(ctx)
+		case <-time.After(delay):
+		}
+	}
+}
+
+// fetch runs a track through the pipeline shared with the server. It
+// returns the path of the new file, or an empty path when the track is
+// skipped.
+func (a *app) fetch(ctx context.Context, t task, bar *bar) (string, error) {
+	p := &pipeline.Pipeline{
+		Config:    a.cfg,
+		Client:    a.client(t.link.Store),
+		Covers:    a.covers,
+		Bandwidth: a.bandwidth,
+		Hooks: pipeline.Hooks{
+			Track: func(track *beatport.Track) {
+				bar.SetLabel(trackLabel(track))
+			},
+			Fallback: func(from, to string) {
+				bar.SetStatus(fmt.Sprintf("no %s, trying %s", from, to))
+			},
+			Progress: bar.Update,
+			Logf:     a.board.Printf,
+		},
+	}
+
+	result, err := p.Run(ctx, pipeline.Track{
+		Link:      t.link,
+		Directory: t.directory,
+		TempPath:  filepath.Join(a.cfg.Downloads.Directory, incompleteDirectory, fmt.Sprintf("%s-%d.temp", t.link.Store, t.link.ID)),
+	})
+	if err != nil {
+		return "", err
+	}
+	if result.Skipped {
+		bar.Done("Skipped %s, %s already exists", bar.label, result.Path)
+		return "", nil
+	}
+	return result.Path, nil
+}
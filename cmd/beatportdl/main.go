@@ -0,0 +1,221 @@
+// cmd/beatportdl/main.go
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/unspok3n/beatportdl-ui/config"
+	"github.com/unspok3n/beatportdl-ui/internal/beatport"
+	"github.com/unspok3n/beatportdl-ui/internal/ratelimit"
+	"github.com/unspok3n/beatportdl-ui/internal/tagger"
+)
+
+// Exit codes, so scripts can tell a partial failure from a total one
+const (
+	exitOK = 0
+	// exitFailure means nothing was downloaded, or the tool could not start
+	exitFailure = 1
+	exitUsage   = 2
+	// exitPartial means some tracks were downloaded and others failed
+	exitPartial = 3
+	// exitInterrupted follows the shell convention for SIGINT
+	exitInterrupted = 130
+)
+
+const usage = `Usage: beatportdl [flags] <url | file.txt | ->...
+
+Downloads Beatport and Beatsource tracks, releases, playlists, charts,
+artists and labels. A .txt file lists one URL per line, "-" or piped
+input reads the URLs from stdin. Every config option can be set with a
+flag such as --downloads.workers, run beatportdl -h to list them.`
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	loaded, err := config.LoadArgs("beatportdl", os.Args[1:], os.Environ())
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "\n"+usage)
+			return exitOK
+		}
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		return exitUsage
+	}
+	for _, warning := range loaded.Warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s: %s\n", loaded.Path, warning)
+	}
+	cfg := loaded.Config
+
+	inputs := loaded.Args
+	if len(inputs) == 0 && !isTerminal(os.Stdin) {
+		inputs = []string{"-"}
+	}
+	if len(inputs) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		return exitUsage
+	}
+	urls, err := readInputs(inputs, os.Stdin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading URLs: %v\n", err)
+		return exitUsage
+	}
+	if len(urls) == 0 {
+		fmt.Fprintln(os.Stderr, "No URLs given")
+		return exitUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	app := newApp(cfg)
+	if err := app.login(ctx, urls); err != nil {
+		fmt.Fprintf(os.Stderr, "Error logging in: %v\n", err)
+		return exitFailure
+	}
+
+	summary := app.run(ctx, urls)
+	app.board.Printf("%d downloaded, %d skipped, %d failed", summary.downloaded, summary.skipped, summary.failed)
+	return exitCode(summary, ctx.Err() != nil)
+}
+
+// exitCode returns the exit code for the outcome of a run
+func exitCode(s summary, interrupted bool) int {
+	switch {
+	case interrupted:
+		return exitInterrupted
+	case s.failed == 0:
+		return exitOK
+	case s.downloaded+s.skipped == 0:
+		return exitFailure
+	default:
+		return exitPartial
+	}
+}
+
+// readInputs collects the URLs from the arguments. An argument ending in
+// .txt is a file listing one URL per line, "-" reads such a list from
+// stdin. Blank lines and lines starting with # are ignored.
+func readInputs(args []string, stdin io.Reader) ([]string, error) {
+	var urls []string
+	for _, arg := range args {
+		switch {
+		case arg == "-":
+			list, err := readList(stdin)
+			if err != nil {
+				return nil, fmt.Errorf("stdin: %w", err)
+			}
+			urls = append(urls, list...)
+		case strings.HasSuffix(strings.ToLower(arg), ".txt"):
+			f, err := os.Open(arg)
+			if err != nil {
+				return nil, err
+			}
+			list, err := readList(f)
+			f.Close()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", arg, err)
+			}
+			urls = append(urls, list...)
+		default:
+			urls = append(urls, arg)
+		}
+	}
+	return urls, nil
+}
+
+func readList(r io.Reader) ([]string, error) {
+	var urls []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	return urls, scanner.Err()
+}
+
+// app holds the clients shared by all downloads of a run
+type app struct {
+	cfg *config.AppConfig
+	// auths keep a separate login and token per store
+	auths   map[beatport.Store]*beatport.Auth
+	clients map[beatport.Store]*beatport.Beatport
+	covers  *tagger.CoverCache
+	// bandwidth caps the combined speed of all downloads
+	bandwidth *ratelimit.Limiter
+	board     *board
+}
+
+func newApp(cfg *config.AppConfig) *app {
+	a := &app{
+		cfg:       cfg,
+		auths:     make(map[beatport.Store]*beatport.Auth),
+		clients:   make(map[beatport.Store]*beatport.Beatport),
+		covers:    tagger.NewCoverCache(&http.Client{}, cfg.Cover.Size),
+		bandwidth: ratelimit.New(float64(cfg.Downloads.BandwidthLimit), int(cfg.Downloads.BandwidthLimit)),
+		board:     newBoard(os.Stderr),
+	}
+	// A TTL of 0 turns the metadata cache off
+	var cache *beatport.Cache
+	if cfg.API.Cache.TTLHours > 0 {
+		cache = beatport.NewCache(cfg.API.Cache.Directory, time.Duration(cfg.API.Cache.TTLHours)*time.Hour)
+	}
+	for _, store := range []beatport.Store{beatport.StoreBeatport, beatport.StoreBeatsource} {
+		a.auths[store] = beatport.NewAuth(cfg.Credentials.Username, cfg.Credentials.Password, cfg.Credentials.TokenCacheFile(string(store)))
+		b := beatport.New(store, cfg.Proxy, a.auths[store])
+		limit := cfg.API.RateLimits[string(store)]
+		b.SetLimiter(ratelimit.New(limit.RequestsPerSecond, limit.Burst))
+		if cache != nil {
+			b.SetCache(cache)
+		}
+		a.clients[store] = b
+	}
+	return a
+}
+
+func (a *app) client(store beatport.Store) *beatport.Beatport {
+	if b, ok := a.clients[store]; ok {
+		return b
+	}
+	return a.clients[beatport.StoreBeatport]
+}
+
+// login logs in to every store the URLs point to. A cached token is used
+// while it is still valid, the configured credentials otherwise.
+func (a *app) login(ctx context.Context, urls []string) error {
+	stores := make(map[beatport.Store]bool)
+	for _, u := range urls {
+		// Invalid URLs are reported once downloading starts
+		if link, err := beatport.ParseUrl(u); err == nil {
+			stores[link.Store] = true
+		}
+	}
+	for store := range stores {
+		auth := a.auths[store]
+		if err := auth.LoadCache(); err == nil {
+			continue
+		}
+		if credentials := a.cfg.Credentials; credentials.Username == "" || credentials.Password == "" {
+			return fmt.Errorf("username and password are not configured, set them in the config file or with --credentials.username and --credentials.password")
+		}
+		if err := auth.Init(ctx, a.client(store)); err != nil {
+			return fmt.Errorf("%s: %w", store, err)
+		}
+	}
+	return nil
+}
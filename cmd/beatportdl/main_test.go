@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadInputs(t *testing.T) {
+	dir := t.TempDir()
+	list := filepath.Join(dir, "urls.TXT")
+	data := "# releases\nhttps://www.beatport.com/release/a/1\n\n  https://www.beatport.com/release/b/2  \n"
+	if err := os.WriteFile(list, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		args  []string
+		stdin string
+		want  []string
+	}{
+		{
+			name: "urls",
+			args: []string{"https://www.beatport.com/track/a/1", "https://www.beatsource.com/track/b/2"},
+			want: []string{"https://www.beatport.com/track/a/1", "https://www.beatsource.com/track/b/2"},
+		},
+		{
+			name: "file",
+			args: []string{list},
+			want: []string{"https://www.beatport.com/release/a/1", "https://www.beatport.com/release/b/2"},
+		},
+		{
+			name:  "stdin between urls",
+			args:  []string{"https://www.beatport.com/track/a/1", "-", "https://www.beatport.com/track/c/3"},
+			stdin: "https://www.beatport.com/track/b/2\n# skipped\n",
+			want:  []string{"https://www.beatport.com/track/a/1", "https://www.beatport.com/track/b/2", "https://www.beatport.com/track/c/3"},
+		},
+		{
+			name:  "empty stdin",
+			args:  []string{"-"},
+			stdin: "\n# nothing\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readInputs(tt.args, strings.NewReader(tt.stdin))
+			if err != nil {
+				t.Fatalf("readInputs: %v", err)
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("readInputs = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := readInputs([]string{filepath.Join(dir, "missing.txt")}, strings.NewReader("")); err == nil {
+		t.Error("readInputs accepted a missing file")
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name        string
+		summary     summary
+		interrupted bool
+		want        int
+	}{
+		{name: "all downloaded", summary: summary{downloaded: 3}, want: exitOK},
+		{name: "all skipped", summary: summary{skipped: 2}, want: exitOK},
+		{name: "nothing to do", want: exitOK},
+		{name: "some failed", summary: summary{downloaded: 2, failed: 1}, want: exitPartial},
+		{name: "skipped and failed", summary: summary{skipped: 1, failed: 1}, want: exitPartial},
+		{name: "all failed", summary: summary{failed: 2}, want: exitFailure},
+		{name: "interrupted", summary: summary{downloaded: 1}, interrupted: true, want: exitInterrupted},
+	}
+
+	for _, tt := range tests {
+		if got := exitCode(tt.summary, tt.interrupted); got != tt.want {
+			t.Errorf("%s: exitCode = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
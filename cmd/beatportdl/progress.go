@@ -0,0 +1,186 @@
+// cmd/beatportdl/progress.go
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/unspok3n/beatportdl-ui/internal/jobs"
+)
+
+const (
+	// progressInterval is how often a bar is redrawn while downloading
+	progressInterval = 200 * time.Millisecond
+
+	// labelWidth and barWidth keep a progress line within 80 columns, a
+	// wrapped line would break moving the cursor back over the bars
+	labelWidth = 32
+	barWidth   = 20
+)
+
+// board draws a progress bar per running download at the bottom of the
+// terminal. Messages are printed above the bars, which are redrawn after
+// every change. When the output is not a terminal, only the messages are
+// printed.
+type board struct {
+	mu    sync.Mutex
+	out   io.Writer
+	tty   bool
+	bars  []*bar
+	drawn int
+}
+
+func newBoard(out *os.File) *board {
+	return &board{out: out, tty: isTerminal(out)}
+}
+
+// isTerminal reports whether f is a character device, which is as close
+// to a terminal check as the standard library gets
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Printf prints a line above the progress bars
+func (b *board) Printf(format string, args ...interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clear()
+	fmt.Fprintf(b.out, format+"\n", args...)
+	b.draw()
+}
+
+// Add shows a new progress bar
+func (b *board) Add(label string) *bar {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bar := &bar{board: b, label: label, status: "starting", meter: jobs.NewMeter(progressInterval)}
+	b.bars = append(b.bars, bar)
+	b.clear()
+	b.draw()
+	return bar
+}
+
+func (b *board) remove(bar *bar) {
+	for i := range b.bars {
+		if b.bars[i] == bar {
+			b.bars = append(b.bars[:i], b.bars[i+1:]...)
+			return
+		}
+	}
+}
+
+// clear moves the cursor back to the first bar and erases the bars
+func (b *board) clear() {
+	if b.drawn > 0 {
+		fmt.Fprintf(b.out, "\033[%dF\033[J", b.drawn)
+		b.drawn = 0
+	}
+}
+
+func (b *board) draw() {
+	if !b.tty {
+		return
+	}
+	for _, bar := range b.bars {
+		fmt.Fprintln(b.out, bar.line())
+	}
+	b.drawn = len(b.bars)
+}
+
+// bar is the progress of a single track
+type bar struct {
+	board    *board
+	label    string
+	status   string
+	meter    *jobs.Meter
+	progress jobs.Progress
+}
+
+// SetLabel replaces the label, once the name of the track is known
+func (p *bar) SetLabel(label string) {
+	p.board.mu.Lock()
+	defer p.board.mu.Unlock()
+	p.label = label
+	p.board.clear()
+	p.board.draw()
+}
+
+// SetStatus shows a status in place of the transfer speed until the next
+// progress update, such as a pending retry
+func (p *bar) SetStatus(status string) {
+	p.board.mu.Lock()
+	defer p.board.mu.Unlock()
+	p.status = status
+	p.board.clear()
+	p.board.draw()
+}
+
+// Update records the bytes downloaded so far. It is meant to be passed to
+// the downloader as its progress function.
+func (p *bar) Update(written, total int64) {
+	progress, ok := p.meter.Update(written, total)
+	if !ok {
+		return
+	}
+	p.board.mu.Lock()
+	defer p.board.mu.Unlock()
+	p.progress = progress
+	p.status = ""
+	p.board.clear()
+	p.board.draw()
+}
+
+// Done removes the bar and prints a message in its place
+func (p *bar) Done(format string, args ...interface{}) {
+	p.board.mu.Lock()
+	defer p.board.mu.Unlock()
+	p.board.clear()
+	p.board.remove(p)
+	fmt.Fprintf(p.board.out, format+"\n", args...)
+	p.board.draw()
+}
+
+func (p *bar) line() string {
+	filled := p.progress.Percent * barWidth / 100
+	status := p.status
+	if status == "" {
+		status = formatBytes(p.progress.Speed) + "/s"
+		if p.progress.ETA > 0 {
+			status += " " + formatDuration(p.progress.ETA)
+		}
+	}
+	return fmt.Sprintf("%-*s [%s%s] %3d%% %s",
+		labelWidth, truncate(p.label, labelWidth),
+		strings.Repeat("#", filled), strings.Repeat("-", barWidth-filled),
+		p.progress.Percent, status)
+}
+
+func truncate(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) <= width {
+		return s
+	}
+	return string(runes[:width-1]) + "…"
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 3; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGT"[exp])
+}
+
+func formatDuration(seconds int64) string {
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
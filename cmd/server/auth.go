@@ -0,0 +1,95 @@
+// cmd/server/auth.go
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/unspok3n/beatportdl-ui/internal/beatport"
+)
+
+// loginTimeout bounds a login so a slow store can't hold its client
+// forever
+const loginTimeout = 30 * time.Second
+
+var (
+	loginMutex sync.Mutex
+	// logins hold the state of the last login of every store
+	logins = make(map[beatport.Store]loginState)
+)
+
+// loginState is the login state reported by GET /auth on top of the
+// token's own
+type loginState struct {
+	beatport.AuthStatus
+	LoggingIn bool   `json:"logging_in,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// login restores the cached token of every store, or logs in with the
+// configured credentials when there is no usable one. Failures are only
+// logged, the clients try to log in again on their next request.
+func login() {
+	if credentials := currentConfig().Credentials; credentials.Username == "" || credentials.Password == "" {
+		log.Println("Warning: username and password are not configured, downloads will fail until they are set")
+		return
+	}
+	for store, auth := range auths {
+		loginStore(store, auth)
+	}
+}
+
+func loginStore(store beatport.Store, auth *beatport.Auth) {
+	setLoginState(store, loginState{LoggingIn: true})
+	err := auth.LoadCache()
+	if err == nil {
+		setLoginState(store, loginState{})
+		log.Printf("Loaded cached %s token", store)
+		return
+	}
+	if errors.Is(err, beatport.ErrLoginIDMismatch) {
+		log.Printf("Cached %s token belongs to different credentials, logging in again", store)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), loginTimeout)
+	defer cancel()
+	if err := auth.Init(ctx, clients[store]); err != nil {
+		setLoginState(store, loginState{Error: err.Error()})
+		log.Printf("Error logging in to %s: %v", store, err)
+		return
+	}
+	setLoginState(store, loginState{})
+	log.Printf("Logged in to %s", store)
+}
+
+func setLoginState(store beatport.Store, state loginState) {
+	loginMutex.Lock()
+	defer loginMutex.Unlock()
+	logins[store] = state
+}
+
+// authHandler reports the login state of every store.
+func authHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	loginMutex.Lock()
+	status := make(map[beatport.Store]loginState, len(auths))
+	for store := range auths {
+		status[store] = logins[store]
+	}
+	loginMutex.Unlock()
+	for store, auth := range auths {
+		// The token is locked while logging in, so only finished logins
+		// report it
+		if state := status[store]; !state.LoggingIn {
+			state.AuthStatus = auth.Status()
+			status[store] = state
+		}
+	}
+	writeJSON(w, http.StatusOK, status)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/unspok3n/beatportdl-ui/internal/beatport"
+)
+
+func TestAuthHandler(t *testing.T) {
+	srv := newTestServer(t)
+	store := beatport.StoreBeatport
+	auths[store] = beatport.NewAuth("user", "secret", filepath.Join(t.TempDir(), "token.json"))
+	t.Cleanup(func() {
+		delete(auths, store)
+		delete(logins, store)
+	})
+
+	tests := []struct {
+		name  string
+		state loginState
+	}{
+		{name: "logging in", state: loginState{LoggingIn: true}},
+		{name: "failed", state: loginState{Error: "invalid credentials"}},
+		{name: "not logged in", state: loginState{}},
+	}
+	for _, tt := range tests {
+		setLoginState(store, tt.state)
+		var status map[beatport.Store]loginState
+		if code := request(t, srv, http.MethodGet, "/auth", "", &status); code != http.StatusOK {
+			t.Fatalf("%s: GET /auth = %d", tt.name, code)
+		}
+		if got := status[store]; got.LoggingIn != tt.state.LoggingIn || got.Error != tt.state.Error || got.LoggedIn {
+			t.Errorf("%s: status = %+v, want %+v", tt.name, got, tt.state)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+// cmd/server/cache.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/unspok3n/beatportdl-ui/internal/beatport"
+	"github.com/unspok3n/beatportdl-ui/internal/server"
+)
+
+type cacheStatus struct {
+	Enabled bool `json:"enabled"`
+	beatport.CacheStats
+}
+
+// cacheHandler reports the metadata cache counters on GET and empties the
+// cache on DELETE.
+func cacheHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		status := cacheStatus{Enabled: metadataCache != nil}
+		if metadataCache != nil {
+			status.CacheStats = metadataCache.Stats()
+		}
+		writeJSON(w, http.StatusOK, status)
+	case http.MethodDelete:
+		if metadataCache == nil {
+			writeError(w, server.NewServerError(http.StatusNotFound, "Metadata cache is disabled"))
+			return
+		}
+		removed, err := metadataCache.Purge()
+		if err != nil {
+			writeError(w, server.NewServerError(http.StatusInternalServerError, fmt.Sprintf("Error purging cache: %v", err)))
+			return
+		}
+		log.Printf("Purged %d metadata cache entries", removed)
+		writeJSON(w, http.StatusOK, map[string]int{"removed": removed})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,165 @@
+// cmd/server/collections.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/google/uuid"
+
+	"github.com/unspok3n/beatportdl-ui/config"
+	"github.com/unspok3n/beatportdl-ui/internal/beatport"
+	"github.com/unspok3n/beatportdl-ui/internal/jobs"
+	"github.com/unspok3n/beatportdl-ui/internal/server"
+)
+
+// collectionPageSize is the number of items requested per page while
+// expanding collections
+const collectionPageSize = 100
+
+// collectionsMutex serializes progress updates of collection jobs, whose
+// children can finish concurrently.
+var collectionsMutex = &sync.Mutex{}
+
+func processCollectionInternal(ctx context.Context, cfg *config.AppConfig, job *jobs.Job) (map[string]interface{}, error) {
+	resp := map[string]interface{}{
+		"status": "downloading",
+	}
+	if job.Expanded {
+		return resp, nil
+	}
+
+	link, err := beatport.ParseUrl(job.TrackURL)
+	if err != nil {
+		resp["status"] = "failed"
+		return resp, server.NewServerError(http.StatusBadRequest, fmt.Sprintf("Error parsing URL '%s': %v", job.TrackURL, err))
+	}
+
+	log.Printf("Expanding %s with ID %d", link.Type, link.ID)
+
+	opts := beatport.PageOptions{PerPage: collectionPageSize}
+	directory, tracks, err := storeClient(link.Store).Expand(ctx, link, cfg.DirectoryNaming, opts)
+	if err != nil {
+		if ctx.Err() != nil {
+			return resp, context.Cause(ctx)
+		}
+		resp["status"] = "failed"
+		return resp, apiError(fmt.Sprintf("Error expanding %s", link.Type), err)
+	}
+
+	// Children created before an interrupted expansion are reused
+	existing := make(map[string]string)
+	for _, child := range jobStore.List() {
+		if child.ParentID == job.ID {
+			existing[child.TrackURL] = child.ID
+		}
+	}
+
+	children := make([]string, 0, len(tracks))
+	var created []string
+	for _, t := range tracks {
+		trackURL := t.Track.StoreUrl()
+		if id, ok := existing[trackURL]; ok {
+			children = append(children, id)
+			continue
+		}
+
+		id := uuid.New().String()
+		err := jobStore.Put(&jobs.Job{
+			ID:        id,
+			Kind:      jobs.KindTrack,
+			TrackURL:  trackURL,
+			Status:    jobs.StatusPending,
+			Quality:   job.Quality,
+			ParentID:  job.ID,
+			Directory: t.Directory,
+			Metadata: map[string]interface{}{
+				"id":      strconv.FormatInt(t.Track.ID, 10),
+				"title":   html.EscapeString(t.Track.Title()),
+				"artists": html.EscapeString(t.Track.Artists.Display(0, "")),
+			},
+		})
+		if err != nil {
+			resp["status"] = "failed"
+			return resp, server.NewServerError(http.StatusInternalServerError, fmt.Sprintf("Error creating job: %v", err))
+		}
+		existing[trackURL] = id
+		children = append(children, id)
+		created = append(created, id)
+	}
+
+	if _, err := jobStore.Update(job.ID, func(j *jobs.Job) {
+		j.Directory = directory
+		j.Children = children
+		j.Expanded = true
+	}); err != nil {
+		resp["status"] = "failed"
+		return resp, server.NewServerError(http.StatusInternalServerError, fmt.Sprintf("Error updating job: %v", err))
+	}
+
+	for _, id := range created {
+		dispatcher.Enqueue(id)
+	}
+	log.Printf("Expanded %s %d into %d track(s)", link.Type, link.ID, len(children))
+
+	return resp, nil
+}
+
+// refreshCollection recomputes the aggregate progress of a collection job
+// and completes it once every remaining child job has finished.
+func refreshCollection(id string) {
+	collectionsMutex.Lock()
+	defer collectionsMutex.Unlock()
+
+	parent, ok := jobStore.Get(id)
+	if !ok || !parent.Expanded {
+		return
+	}
+
+	progress := &jobs.CollectionProgress{}
+	for _, childID := range parent.Children {
+		child, ok := jobStore.Get(childID)
+		if !ok {
+			// Deleted children no longer count towards the collection
+			continue
+		}
+		progress.Total++
+		switch child.Status {
+		case jobs.StatusCompleted:
+			progress.Completed++
+		case jobs.StatusFailed:
+			progress.Failed++
+		case jobs.StatusCancelled:
+			progress.Cancelled++
+		}
+	}
+	finished := progress.Completed + progress.Failed + progress.Cancelled
+	progress.Percent = 100
+	if progress.Total > 0 {
+		progress.Percent = finished * 100 / progress.Total
+	}
+
+	job, err := jobStore.Update(id, func(j *jobs.Job) {
+		j.Collection = progress
+		if j.Status != jobs.StatusDownloading || finished < progress.Total {
+			return
+		}
+		if progress.Failed > 0 {
+			j.Status = jobs.StatusFailed
+		} else {
+			j.Status = jobs.StatusCompleted
+		}
+	})
+	if err != nil {
+		log.Printf("Error updating collection %s: %v", id, err)
+		return
+	}
+	if !job.Unfinished() && finished == progress.Total {
+		log.Printf("Collection %s finished: %d completed, %d failed, %d cancelled", job.TrackURL, progress.Completed, progress.Failed, progress.Cancelled)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/unspok3n/beatportdl-ui/internal/jobs"
+)
+
+func TestRefreshCollection(t *testing.T) {
+	newTestServer(t)
+	putJobs(t,
+		&jobs.Job{ID: "release", Kind: jobs.KindCollection, Status: jobs.StatusDownloading, Expanded: true, Children: []string{"a", "b", "c", "d"}},
+		&jobs.Job{ID: "a", Status: jobs.StatusCompleted, ParentID: "release"},
+		&jobs.Job{ID: "b", Status: jobs.StatusFailed, ParentID: "release"},
+		&jobs.Job{ID: "c", Status: jobs.StatusCancelled, ParentID: "release"},
+		&jobs.Job{ID: "d", Status: jobs.StatusDownloading, ParentID: "release"},
+	)
+
+	// Finished children of a running collection are kept by pruning, so
+	// the failure still counts once the last child is done
+	if _, err := jobStore.Prune(-time.Minute); err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	refreshCollection("release")
+	job, _ := jobStore.Get("release")
+	want := jobs.CollectionProgress{Total: 4, Completed: 1, Failed: 1, Cancelled: 1, Percent: 75}
+	if job.Status != jobs.StatusDownloading || job.Collection == nil || *job.Collection != want {
+		t.Fatalf("collection is %s with %+v, want downloading with %+v", job.Status, job.Collection, want)
+	}
+
+	if _, err := jobStore.Update("d", func(j *jobs.Job) { j.Status = jobs.StatusCompleted }); err != nil {
+		t.Fatal(err)
+	}
+	refreshCollection("release")
+	job, _ = jobStore.Get("release")
+	want = jobs.CollectionProgress{Total: 4, Completed: 2, Failed: 1, Cancelled: 1, Percent: 100}
+	if job.Status != jobs.StatusFailed || *job.Collection != want {
+		t.Errorf("collection is %s with %+v, want failed with %+v", job.Status, job.Collection, want)
+	}
+}
+
+func TestRefreshCollectionDeletedChild(t *testing.T) {
+	newTestServer(t)
+	putJobs(t,
+		&jobs.Job{ID: "release", Kind: jobs.KindCollection, Status: jobs.StatusDownloading, Expanded: true, Children: []string{"a", "b", "c"}},
+		&jobs.Job{ID: "a", Status: jobs.StatusCompleted, ParentID: "release"},
+		&jobs.Job{ID: "b", Status: jobs.StatusCancelled, ParentID: "release"},
+		&jobs.Job{ID: "c", Status: jobs.StatusPending, ParentID: "release"},
+	)
+	if err := deleteJob("b"); err != nil {
+		t.Fatalf("deleteJob: %v", err)
+	}
+
+	// A deleted child no longer counts, rather than counting as completed
+	refreshCollection("release")
+	job, _ := jobStore.Get("release")
+	want := jobs.CollectionProgress{Total: 2, Completed: 1, Percent: 50}
+	if job.Status != jobs.StatusDownloading || job.Collection == nil || *job.Collection != want {
+		t.Errorf("collection is %s with %+v, want downloading with %+v", job.Status, job.Collection, want)
+	}
+}
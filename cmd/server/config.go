@@ -0,0 +1,132 @@
+// cmd/server/config.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/unspok3n/beatportdl-ui/config"
+	"github.com/unspok3n/beatportdl-ui/internal/server"
+)
+
+const maxConfigBody = 1 << 20
+
+// configWriteMutex keeps concurrent updates from overwriting each other
+var configWriteMutex sync.Mutex
+
+// configureHandler returns the current configuration on GET and updates it
+// with a JSON Merge Patch on PATCH. PUT and POST are accepted as well for
+// older clients and behave like PATCH. The password is never returned.
+func configureHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, currentConfig())
+	case http.MethodPatch, http.MethodPut, http.MethodPost:
+		updateConfig(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// updateConfig applies the patch in the request body to the config file,
+// which is then reloaded like after any other change. The patch may be
+// JSON or YAML. Options overridden by the environment or the command line
+// keep their override, the patched ones are listed in the
+// X-Config-Overridden header.
+func updateConfig(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxConfigBody))
+	if err != nil {
+		log.Printf("Error reading config request body: %v", err)
+		writeError(w, server.NewServerError(http.StatusBadRequest, "Error reading request body"))
+		return
+	}
+	patch, err := config.DecodePatch(body)
+	if err != nil {
+		writeError(w, server.NewServerError(http.StatusBadRequest, fmt.Sprintf("Error parsing config: %v", err)))
+		return
+	}
+
+	configWriteMutex.Lock()
+	defer configWriteMutex.Unlock()
+
+	layers := cfgLayers.Load()
+	path := layers.Path
+	document, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		writeError(w, server.NewServerError(http.StatusInternalServerError, fmt.Sprintf("Error reading config: %v", err)))
+		return
+	}
+	// Only the keys of the file and the patch are written, options left
+	// out keep following their default and their provenance
+	document, _, err = config.ApplyPatch(document, patch)
+	if err != nil {
+		writeConfigError(w, err)
+		return
+	}
+	// The file must still be valid once the environment and the flags
+	// are applied on top, or the reload would reject it after saving
+	if _, err := layers.Preview(document); err != nil {
+		writeConfigError(w, err)
+		return
+	}
+	if err := config.SaveDocument(path, document); err != nil {
+		writeError(w, server.NewServerError(http.StatusInternalServerError, fmt.Sprintf("Error writing config: %v", err)))
+		return
+	}
+	if err := reloadConfig(); err != nil {
+		writeError(w, server.NewServerError(http.StatusInternalServerError, fmt.Sprintf("Config saved but not applied: %v", err)))
+		return
+	}
+
+	log.Println("Configuration updated successfully")
+	if overridden := layers.Overridden(patch); len(overridden) > 0 {
+		log.Printf("Overridden by the environment or the command line, the change takes no effect: %s", strings.Join(overridden, ", "))
+		w.Header().Set("X-Config-Overridden", strings.Join(overridden, ", "))
+	}
+	writeJSON(w, http.StatusOK, currentConfig())
+}
+
+// writeConfigError answers with every problem found in a rejected config,
+// each with the path of its option.
+func writeConfigError(w http.ResponseWriter, err error) {
+	var validationErrs config.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  err.Error(),
+			"errors": validationErrs,
+		})
+		return
+	}
+	writeError(w, server.NewServerError(http.StatusBadRequest, fmt.Sprintf("Invalid config: %v", err)))
+}
+
+// configSourcesHandler lists the effective value of every option and the
+// layer it came from.
+func configSourcesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	layers := cfgLayers.Load()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"path":    layers.Path,
+		"options": layers.Options(),
+	})
+}
+
+// configSchemaHandler returns the JSON Schema of the configuration, for
+// clients building a settings form.
+func configSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, config.Schema())
+}
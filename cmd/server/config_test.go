@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestUpdateConfig(t *testing.T) {
+	srv := newTestServer(t, "--downloads.workers", "2", "--downloads.retry.baseDelaySeconds", "300")
+	path := cfgLayers.Load().Path
+
+	patch := func(body string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPatch, srv.URL+"/config", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	// Valid over the defaults, but not below the flags
+	resp := patch(`{"downloads": {"retry": {"maxDelaySeconds": 100}}}`)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("PATCH below an override = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("rejected patch was saved: %v", err)
+	}
+
+	resp = patch(`{"downloads": {"workers": 4, "quality": "high"}}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("PATCH = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if got := resp.Header.Get("X-Config-Overridden"); got != "downloads.workers" {
+		t.Errorf("X-Config-Overridden = %q, want downloads.workers", got)
+	}
+	cfg := currentConfig()
+	if cfg.Downloads.Workers != 2 || cfg.Downloads.Quality != "high" {
+		t.Errorf("downloads = %+v, want 2 workers from the flag and high quality", cfg.Downloads)
+	}
+	if data, _ := os.ReadFile(path); !strings.Contains(string(data), "workers: 4") {
+		t.Errorf("config file = %q, want the patched workers", data)
+	}
+
+	resp = patch(`{"downloads": {"quality": "medium"}}`)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("X-Config-Overridden") != "" {
+		t.Errorf("PATCH = %d with overrides %q, want %d and none", resp.StatusCode, resp.Header.Get("X-Config-Overridden"), http.StatusOK)
+	}
+}
@@ -0,0 +1,175 @@
+// cmd/server/events.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/unspok3n/beatportdl-ui/internal/events"
+	"github.com/unspok3n/beatportdl-ui/internal/jobs"
+)
+
+const (
+	eventHistorySize  = 1000
+	progressInterval  = 500 * time.Millisecond
+	eventsKeepAlive   = 15 * time.Second
+	eventsRetryMillis = 3000
+
+	// eventsReset tells a resuming client that events were lost and it has
+	// to reload the job list
+	eventsReset = "reset"
+)
+
+var (
+	broker = events.NewBroker(eventHistorySize)
+
+	downloadProgress      = make(map[string]jobs.Progress)
+	downloadProgressMutex = &sync.Mutex{}
+)
+
+// jobStatus is a job along with the progress of its running download.
+type jobStatus struct {
+	*jobs.Job
+	Progress *jobs.Progress `json:"progress,omitempty"`
+}
+
+type statusChange struct {
+	Status   jobs.Status `json:"status"`
+	Previous jobs.Status `json:"previous"`
+}
+
+type jobError struct {
+	Code  interface{} `json:"code,omitempty"`
+	Error interface{} `json:"error"`
+}
+
+func withProgress(job *jobs.Job) jobStatus {
+	status := jobStatus{Job: job}
+	downloadProgressMutex.Lock()
+	if p, ok := downloadProgress[job.ID]; ok {
+		status.Progress = &p
+	}
+	downloadProgressMutex.Unlock()
+	return status
+}
+
+// reportProgress records the progress of a running download and publishes
+// it.
+func reportProgress(id string, p jobs.Progress) {
+	downloadProgressMutex.Lock()
+	downloadProgress[id] = p
+	downloadProgressMutex.Unlock()
+	broker.Publish(events.JobProgress, id, p)
+}
+
+func clearProgress(id string) {
+	downloadProgressMutex.Lock()
+	delete(downloadProgress, id)
+	downloadProgressMutex.Unlock()
+}
+
+// publishJobChange turns job store changes into events.
+func publishJobChange(previous, current *jobs.Job) {
+	switch {
+	case current == nil:
+		clearProgress(previous.ID)
+		broker.Publish(events.JobDeleted, previous.ID, nil)
+	case previous == nil:
+		broker.Publish(events.JobCreated, current.ID, current)
+	case previous.Status != current.Status:
+		if current.Status != jobs.StatusDownloading {
+			clearProgress(current.ID)
+		}
+		broker.Publish(events.JobStatus, current.ID, statusChange{Status: current.Status, Previous: previous.Status})
+		if current.Status == jobs.StatusFailed {
+			broker.Publish(events.JobError, current.ID, jobError{Code: current.Metadata["code"], Error: current.Metadata["error"]})
+		}
+	case current.Collection != nil && (previous.Collection == nil || *previous.Collection != *current.Collection):
+		broker.Publish(events.JobProgress, current.ID, current.Collection)
+	}
+}
+
+// eventsHandler streams job events as Server-Sent Events. A client resumes
+// from the Last-Event-ID header, or the last_event_id query parameter for
+// clients that cannot set headers.
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	var lastID uint64
+	if lastEventID != "" {
+		var err error
+		if lastID, err = strconv.ParseUint(lastEventID, 10, 64); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid Last-Event-ID: %s", lastEventID), http.StatusBadRequest)
+			return
+		}
+	}
+
+	missed, complete, ch, unsubscribe := broker.Subscribe(lastID)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", eventsRetryMillis)
+	if !complete {
+		fmt.Fprintf(w, "event: %s\ndata: {}\n\n", eventsReset)
+	}
+	for _, event := range missed {
+		if err := writeEvent(w, event); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-ch:
+			if !ok {
+				// Too slow to keep up, the client reconnects and resumes
+				return
+			}
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event events.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error encoding event %d: %v", event.ID, err)
+		return nil
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
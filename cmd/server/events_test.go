@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/unspok3n/beatportdl-ui/internal/events"
+	"github.com/unspok3n/beatportdl-ui/internal/jobs"
+)
+
+// sseEvent is an event read from the /events stream
+type sseEvent struct {
+	id   uint64
+	name string
+}
+
+// readEvents reads the /events stream until n events with an ID arrived
+func readEvents(t *testing.T, r *bufio.Reader, n int) []sseEvent {
+	t.Helper()
+	var list []sseEvent
+	var current sseEvent
+	for len(list) < n {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading events: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			current.id, _ = strconv.ParseUint(strings.TrimPrefix(line, "id: "), 10, 64)
+		case strings.HasPrefix(line, "event: "):
+			current.name = strings.TrimPrefix(line, "event: ")
+		case line == "":
+			if current.name != "" {
+				list = append(list, current)
+			}
+			current = sseEvent{}
+		}
+	}
+	return list
+}
+
+// openEvents connects to /events with the given Last-Event-ID
+func openEvents(t *testing.T, url, lastEventID string) *bufio.Reader {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /events = %d", resp.StatusCode)
+	}
+	return bufio.NewReader(resp.Body)
+}
+
+func TestEventsReplay(t *testing.T) {
+	srv := newTestServer(t)
+	putJobs(t, &jobs.Job{ID: "a", Status: jobs.StatusPending})
+	first := broker.Publish(events.JobStatus, "a", nil)
+	putJobs(t, &jobs.Job{ID: "b", Status: jobs.StatusPending})
+	if _, err := jobStore.Update("a", func(j *jobs.Job) { j.Status = jobs.StatusCancelled }); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the events after the last one seen are replayed, then the
+	// stream goes on live
+	r := openEvents(t, srv.URL, strconv.FormatUint(first.ID, 10))
+	got := readEvents(t, r, 2)
+	if got[0].id != first.ID+1 || got[0].name != events.JobCreated || got[1].id != first.ID+2 || got[1].name != events.JobStatus {
+		t.Fatalf("replayed %+v, want %s and %s after %d", got, events.JobCreated, events.JobStatus, first.ID)
+	}
+
+	if err := deleteJob("a"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readEvents(t, r, 1); got[0].name != events.JobDeleted || got[0].id != first.ID+3 {
+		t.Errorf("live event = %+v, want %s", got[0], events.JobDeleted)
+	}
+}
+
+func TestEventsResetOnUnknownID(t *testing.T) {
+	srv := newTestServer(t)
+	putJobs(t, &jobs.Job{ID: "a", Status: jobs.StatusPending})
+
+	// An ID from before a restart asks the client to reload
+	r := openEvents(t, srv.URL, "1000")
+	if got := readEvents(t, r, 1); got[0].name != eventsReset {
+		t.Errorf("first event = %+v, want %s", got[0], eventsReset)
+	}
+
+	if code := request(t, srv, http.MethodGet, "/events?last_event_id=x", "", nil); code != http.StatusBadRequest {
+		t.Errorf("GET /events with an invalid ID = %d, want %d", code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,443 @@
+// cmd/server/jobs.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/unspok3n/beatportdl-ui/config"
+	"github.com/unspok3n/beatportdl-ui/internal/jobs"
+	"github.com/unspok3n/beatportdl-ui/internal/pipeline"
+	"github.com/unspok3n/beatportdl-ui/internal/server"
+	"github.com/unspok3n/beatportdl-ui/internal/validator"
+)
+
+const (
+	defaultJobsPerPage = 50
+	maxJobsPerPage     = 500
+
+	incompleteDirectory = ".incomplete"
+
+	// jobStopTimeout bounds the wait for a stopped job to finish unwinding
+	// before it is started again
+	jobStopTimeout = 10 * time.Second
+)
+
+var (
+	errJobCancelled = errors.New("job cancelled")
+	errJobPaused    = errors.New("job paused")
+)
+
+// jobActions are the actions that can be applied to a single job
+var jobActions = map[string]func(id string) error{
+	"cancel":     cancelJob,
+	"pause":      pauseJob,
+	"resume":     resumeJob,
+	"prioritize": prioritizeJob,
+	"retry":      retryJob,
+}
+
+// activeJob is the registration of a running job
+type activeJob struct {
+	cancel context.CancelCauseFunc
+	// stopped is closed once the job has stopped writing
+	stopped chan struct{}
+}
+
+var (
+	activeJobs      = make(map[string]*activeJob)
+	activeJobsMutex = &sync.Mutex{}
+)
+
+// startJob registers a running job and returns the context its download
+// must honour. The returned function unregisters the job again.
+func startJob(id string) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	entry := &activeJob{cancel: cancel, stopped: make(chan struct{})}
+
+	activeJobsMutex.Lock()
+	activeJobs[id] = entry
+	activeJobsMutex.Unlock()
+
+	return ctx, func() {
+		activeJobsMutex.Lock()
+		// Only the own registration is removed, never one of a later run
+		if activeJobs[id] == entry {
+			delete(activeJobs, id)
+		}
+		activeJobsMutex.Unlock()
+		cancel(nil)
+		close(entry.stopped)
+	}
+}
+
+// waitForJob waits until a stopped job has finished unwinding, so running
+// it again doesn't write to the same file twice. It reports whether the
+// job stopped within the timeout.
+func waitForJob(id string, timeout time.Duration) bool {
+	activeJobsMutex.Lock()
+	entry, ok := activeJobs[id]
+	activeJobsMutex.Unlock()
+	if !ok {
+		return true
+	}
+
+	select {
+	case <-entry.stopped:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// stopJob aborts a running job with the given cause. It reports whether
+// the job was running.
+func stopJob(id string, cause error) bool {
+	activeJobsMutex.Lock()
+	defer activeJobsMutex.Unlock()
+
+	entry, ok := activeJobs[id]
+	if ok {
+		entry.cancel(cause)
+	}
+	return ok
+}
+
+// jobTempPath returns where a job's download is kept until it is moved
+// into place. It lives inside the downloads directory so the final move is
+// a rename on the same filesystem. The path is recorded on the job when it
+// first starts, so changing the downloads directory later doesn't orphan a
+// partial download.
+func jobTempPath(cfg *config.AppConfig, id string) string {
+	return filepath.Join(cfg.Downloads.Directory, incompleteDirectory, id+".temp")
+}
+
+func removeJobFiles(job *jobs.Job) {
+	path := job.TempPath
+	if path == "" {
+		// The job never started, or started before paths were recorded
+		path = jobTempPath(currentConfig(), job.ID)
+	}
+	if err := pipeline.Remove(path); err != nil {
+		log.Printf("Error removing temporary files for job %s: %v", job.ID, err)
+	}
+}
+
+// jobsPage is a single page of the job listing, shaped like the paginated
+// responses of the Beatport API.
+type jobsPage struct {
+	Count   int         `json:"count"`
+	Page    int         `json:"page"`
+	PerPage int         `json:"per_page"`
+	Results []jobStatus `json:"results"`
+}
+
+func jobsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	page, err := positiveQueryInt(query.Get("page"), 1)
+	if err != nil {
+		writeError(w, server.NewServerError(http.StatusBadRequest, fmt.Sprintf("Invalid page: %v", err)))
+		return
+	}
+	perPage, err := positiveQueryInt(query.Get("per_page"), defaultJobsPerPage)
+	if err != nil {
+		writeError(w, server.NewServerError(http.StatusBadRequest, fmt.Sprintf("Invalid per_page: %v", err)))
+		return
+	}
+	perPage = min(perPage, maxJobsPerPage)
+
+	statuses := query["status"]
+	for _, status := range statuses {
+		if !validator.PermittedValue(jobs.Status(status), jobs.Statuses...) {
+			writeError(w, server.NewServerError(http.StatusBadRequest, fmt.Sprintf("Invalid status: %s", status)))
+			return
+		}
+	}
+
+	filtered := make([]jobStatus, 0)
+	for _, job := range jobStore.List() {
+		if len(statuses) > 0 && !validator.PermittedValue(string(job.Status), statuses...) {
+			continue
+		}
+		filtered = append(filtered, withProgress(job))
+	}
+
+	start := min((page-1)*perPage, len(filtered))
+	end := min(start+perPage, len(filtered))
+
+	writeJSON(w, http.StatusOK, jobsPage{
+		Count:   len(filtered),
+		Page:    page,
+		PerPage: perPage,
+		Results: filtered[start:end],
+	})
+}
+
+func jobHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	switch r.Method {
+	case http.MethodGet:
+		job, ok := jobStore.Get(id)
+		if !ok {
+			writeError(w, server.NewServerError(http.StatusNotFound, fmt.Sprintf("Job %s not found", id)))
+			return
+		}
+		writeJSON(w, http.StatusOK, withProgress(job))
+	case http.MethodDelete:
+		if err := deleteJob(id); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		log.Printf("Deleted job %s", id)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func jobActionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.PathValue("id")
+	action := r.PathValue("action")
+	apply, ok := jobActions[action]
+	if !ok {
+		writeError(w, server.NewServerError(http.StatusNotFound, fmt.Sprintf("Unknown job action: %s", action)))
+		return
+	}
+	if err := apply(id); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// The job may have been deleted in the meantime
+	job, ok := jobStore.Get(id)
+	if !ok {
+		writeError(w, server.NewServerError(http.StatusNotFound, fmt.Sprintf("Job %s not found", id)))
+		return
+	}
+	writeJSON(w, http.StatusOK, withProgress(job))
+}
+
+// transitionJob moves a job to the target status if its current status is
+// one of from.
+func transitionJob(id string, target jobs.Status, from ...jobs.Status) (*jobs.Job, error) {
+	var current jobs.Status
+	job, err := jobStore.Update(id, func(j *jobs.Job) {
+		current = j.Status
+		if validator.PermittedValue(j.Status, from...) {
+			j.Status = target
+		}
+	})
+	if err != nil {
+		if errors.Is(err, jobs.ErrJobNotFound) {
+			return nil, server.NewServerError(http.StatusNotFound, fmt.Sprintf("Job %s not found", id))
+		}
+		return nil, server.NewServerError(http.StatusInternalServerError, fmt.Sprintf("Error updating job: %v", err))
+	}
+	if !validator.PermittedValue(current, from...) {
+		return nil, server.NewServerError(http.StatusConflict, fmt.Sprintf("Job %s is %s", id, current))
+	}
+	return job, nil
+}
+
+// applyToChildren runs action on every child of a collection job. Children
+// that are already in a state the action does not apply to are skipped.
+func applyToChildren(job *jobs.Job, action func(id string) error) {
+	for _, childID := range job.Children {
+		if err := action(childID); err != nil {
+			var serverErr *server.ServerError
+			if errors.As(err, &serverErr) && serverErr.Code == http.StatusConflict {
+				continue
+			}
+			log.Printf("Error updating child job %s: %v", childID, err)
+		}
+	}
+}
+
+// cancelJob stops a job for good and removes its partial file.
+func cancelJob(id string) error {
+	job, err := transitionJob(id, jobs.StatusCancelled, jobs.StatusPending, jobs.StatusDownloading, jobs.StatusPaused)
+	if err != nil {
+		return err
+	}
+	// A running download removes its own file once it has stopped writing
+	if !stopJob(id, errJobCancelled) {
+		removeJobFiles(job)
+	}
+	applyToChildren(job, cancelJob)
+	log.Printf("Cancelled job %s", id)
+	return nil
+}
+
+// pauseJob stops a job but keeps its partial file so it can be resumed.
+func pauseJob(id string) error {
+	job, err := transitionJob(id, jobs.StatusPaused, jobs.StatusPending, jobs.StatusDownloading)
+	if err != nil {
+		return err
+	}
+	stopJob(id, errJobPaused)
+	applyToChildren(job, pauseJob)
+	log.Printf("Paused job %s", id)
+	return nil
+}
+
+// retryJob queues a failed job again with a fresh set of attempts. The
+// failed children of a collection are retried along with it.
+func retryJob(id string) error {
+	job, ok := jobStore.Get(id)
+	if !ok {
+		return server.NewServerError(http.StatusNotFound, fmt.Sprintf("Job %s not found", id))
+	}
+	if job.Status != jobs.StatusFailed {
+		return server.NewServerError(http.StatusConflict, fmt.Sprintf("Job %s is %s", id, job.Status))
+	}
+	if !waitForJob(id, jobStopTimeout) {
+		return server.NewServerError(http.StatusConflict, fmt.Sprintf("Job %s is still stopping", id))
+	}
+	// Children go first so the collection doesn't finish again right away
+	applyToChildren(job, retryJob)
+	if _, err := transitionJob(id, jobs.StatusPending, jobs.StatusFailed); err != nil {
+		return err
+	}
+	if _, err := jobStore.Update(id, func(j *jobs.Job) {
+		j.Attempts = 0
+		j.NextRetryAt = nil
+	}); err != nil {
+		return server.NewServerError(http.StatusInternalServerError, fmt.Sprintf("Error updating job: %v", err))
+	}
+	dispatcher.Enqueue(id)
+	log.Printf("Retrying job %s", id)
+	return nil
+}
+
+// scheduleJob queues a pending job, or waits until its next retry time.
+func scheduleJob(job *jobs.Job) {
+	if job.NextRetryAt == nil || !job.NextRetryAt.After(time.Now()) {
+		dispatcher.Enqueue(job.ID)
+		return
+	}
+	at := *job.NextRetryAt
+	time.AfterFunc(time.Until(at), func() {
+		// The job may have been resumed, retried or cancelled in the meantime
+		current, ok := jobStore.Get(job.ID)
+		if ok && current.Status == jobs.StatusPending && current.NextRetryAt != nil && current.NextRetryAt.Equal(at) {
+			dispatcher.Enqueue(job.ID)
+		}
+	})
+}
+
+// prioritizeJob moves a pending job, or the pending children of a
+// collection, to the front of the queue.
+func prioritizeJob(id string) error {
+	job, ok := jobStore.Get(id)
+	if !ok {
+		return server.NewServerError(http.StatusNotFound, fmt.Sprintf("Job %s not found", id))
+	}
+	if job.Status != jobs.StatusPending && !(job.IsCollection() && job.Status == jobs.StatusDownloading) {
+		return server.NewServerError(http.StatusConflict, fmt.Sprintf("Job %s is %s", id, job.Status))
+	}
+
+	dispatcher.Prioritize(id)
+	// Going backwards keeps the children in their original order
+	for i := len(job.Children) - 1; i >= 0; i-- {
+		if child, ok := jobStore.Get(job.Children[i]); ok && child.Status == jobs.StatusPending {
+			dispatcher.Prioritize(child.ID)
+		}
+	}
+	log.Printf("Prioritized job %s", id)
+	return nil
+}
+
+// resumeJob queues a paused job again.
+func resumeJob(id string) error {
+	// A job paused a moment ago may still be unwinding
+	if job, ok := jobStore.Get(id); ok && job.Status == jobs.StatusPaused && !waitForJob(id, jobStopTimeout) {
+		return server.NewServerError(http.StatusConflict, fmt.Sprintf("Job %s is still stopping", id))
+	}
+	job, err := transitionJob(id, jobs.StatusPending, jobs.StatusPaused)
+	if err != nil {
+		return err
+	}
+	dispatcher.Enqueue(id)
+	applyToChildren(job, resumeJob)
+	log.Printf("Resumed job %s", id)
+	return nil
+}
+
+// deleteJob removes a finished job. Jobs that are still pending or
+// downloading cannot be deleted.
+func deleteJob(id string) error {
+	job, ok := jobStore.Get(id)
+	if !ok {
+		return server.NewServerError(http.StatusNotFound, fmt.Sprintf("Job %s not found", id))
+	}
+	if !job.Finished() {
+		return server.NewServerError(http.StatusConflict, fmt.Sprintf("Job %s is %s and cannot be deleted", id, job.Status))
+	}
+	// A collection goes with all of its children or not at all
+	for _, childID := range job.Children {
+		if child, ok := jobStore.Get(childID); ok && !child.Finished() {
+			return server.NewServerError(http.StatusConflict, fmt.Sprintf("Job %s has a %s child %s and cannot be deleted", id, child.Status, childID))
+		}
+	}
+	if err := jobStore.Delete(id); err != nil {
+		if errors.Is(err, jobs.ErrJobNotFound) {
+			return server.NewServerError(http.StatusNotFound, fmt.Sprintf("Job %s not found", id))
+		}
+		return server.NewServerError(http.StatusInternalServerError, fmt.Sprintf("Error deleting job: %v", err))
+	}
+	removeJobFiles(job)
+	applyToChildren(job, deleteJob)
+	return nil
+}
+
+func positiveQueryInt(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if n < 1 {
+		return 0, fmt.Errorf("must be at least 1")
+	}
+	return n, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	var serverErr *server.ServerError
+	if errors.As(err, &serverErr) {
+		code = serverErr.Code
+		writeJSON(w, code, map[string]string{"error": serverErr.Message})
+		return
+	}
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/unspok3n/beatportdl-ui/internal/beatport"
+	"github.com/unspok3n/beatportdl-ui/internal/downloader"
+	"github.com/unspok3n/beatportdl-ui/internal/jobs"
+)
+
+func TestJobsHandler(t *testing.T) {
+	srv := newTestServer(t)
+	putJobs(t,
+		&jobs.Job{ID: "1", Status: jobs.StatusCompleted},
+		&jobs.Job{ID: "2", Status: jobs.StatusFailed},
+		&jobs.Job{ID: "3", Status: jobs.StatusPending},
+		&jobs.Job{ID: "4", Status: jobs.StatusCompleted},
+		&jobs.Job{ID: "5", Status: jobs.StatusPaused},
+	)
+
+	tests := []struct {
+		query   string
+		count   int
+		page    int
+		perPage int
+		ids     string
+	}{
+		{query: "", count: 5, page: 1, perPage: defaultJobsPerPage, ids: "1,2,3,4,5"},
+		{query: "?per_page=2", count: 5, page: 1, perPage: 2, ids: "1,2"},
+		{query: "?per_page=2&page=3", count: 5, page: 3, perPage: 2, ids: "5"},
+		{query: "?per_page=2&page=4", count: 5, page: 4, perPage: 2, ids: ""},
+		{query: "?per_page=100000", count: 5, page: 1, perPage: maxJobsPerPage, ids: "1,2,3,4,5"},
+		{query: "?status=completed", count: 2, page: 1, perPage: defaultJobsPerPage, ids: "1,4"},
+		{query: "?status=completed&status=paused&per_page=2&page=2", count: 3, page: 2, perPage: 2, ids: "5"},
+		{query: "?status=cancelled", count: 0, page: 1, perPage: defaultJobsPerPage, ids: ""},
+	}
+
+	for _, tt := range tests {
+		var page jobsPage
+		if code := request(t, srv, http.MethodGet, "/jobs"+tt.query, "", &page); code != http.StatusOK {
+			t.Errorf("GET /jobs%s = %d", tt.query, code)
+			continue
+		}
+		ids := make([]string, 0, len(page.Results))
+		for _, job := range page.Results {
+			ids = append(ids, job.ID)
+		}
+		if page.Count != tt.count || page.Page != tt.page || page.PerPage != tt.perPage || strings.Join(ids, ",") != tt.ids {
+			t.Errorf("GET /jobs%s = count %d, page %d of %d: %v; want count %d, page %d of %d: %s",
+				tt.query, page.Count, page.Page, page.PerPage, ids, tt.count, tt.page, tt.perPage, tt.ids)
+		}
+	}
+
+	for _, query := range []string{"?page=0", "?page=x", "?per_page=-1", "?status=done"} {
+		if code := request(t, srv, http.MethodGet, "/jobs"+query, "", nil); code != http.StatusBadRequest {
+			t.Errorf("GET /jobs%s = %d, want %d", query, code, http.StatusBadRequest)
+		}
+	}
+	if code := request(t, srv, http.MethodPost, "/jobs", "", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /jobs = %d, want %d", code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestJobActions(t *testing.T) {
+	srv := newTestServer(t)
+	putJobs(t,
+		&jobs.Job{ID: "pending", Status: jobs.StatusPending},
+		&jobs.Job{ID: "failed", Status: jobs.StatusFailed, Attempts: 3},
+		&jobs.Job{ID: "done", Status: jobs.StatusCompleted},
+	)
+
+	tests := []struct {
+		method string
+		path   string
+		code   int
+		// status is the status of the job in the response
+		status jobs.Status
+	}{
+		{method: http.MethodPost, path: "/jobs/pending/prioritize", code: http.StatusOK, status: jobs.StatusPending},
+		{method: http.MethodPost, path: "/jobs/pending/pause", code: http.StatusOK, status: jobs.StatusPaused},
+		{method: http.MethodPost, path: "/jobs/pending/pause", code: http.StatusConflict},
+		{method: http.MethodPost, path: "/jobs/pending/resume", code: http.StatusOK, status: jobs.StatusPending},
+		{method: http.MethodPost, path: "/jobs/pending/cancel", code: http.StatusOK, status: jobs.StatusCancelled},
+		{method: http.MethodPost, path: "/jobs/pending/resume", code: http.StatusConflict},
+		{method: http.MethodPost, path: "/jobs/failed/retry", code: http.StatusOK, status: jobs.StatusPending},
+		{method: http.MethodPost, path: "/jobs/done/retry", code: http.StatusConflict},
+		{method: http.MethodPost, path: "/jobs/missing/cancel", code: http.StatusNotFound},
+		{method: http.MethodPost, path: "/jobs/missing/retry", code: http.StatusNotFound},
+		{method: http.MethodPost, path: "/jobs/done/explode", code: http.StatusNotFound},
+		{method: http.MethodGet, path: "/jobs/done/cancel", code: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		var job jobStatus
+		code := request(t, srv, tt.method, tt.path, "", &job)
+		if code != tt.code {
+			t.Errorf("%s %s = %d, want %d", tt.method, tt.path, code, tt.code)
+			continue
+		}
+		if tt.status != "" && (job.Job == nil || job.Status != tt.status) {
+			t.Errorf("%s %s returned %+v, want status %s", tt.method, tt.path, job.Job, tt.status)
+		}
+	}
+
+	if job, _ := jobStore.Get("failed"); job.Attempts != 0 {
+		t.Errorf("retried job has %d attempts, want 0", job.Attempts)
+	}
+}
+
+func TestJobActionMatchesJobResponse(t *testing.T) {
+	srv := newTestServer(t)
+	putJobs(t, &jobs.Job{ID: "a", Status: jobs.StatusPending})
+	reportProgress("a", jobs.Progress{Bytes: 10, Total: 100, Percent: 10})
+
+	var got, action jobStatus
+	request(t, srv, http.MethodGet, "/jobs/a", "", &got)
+	if code := request(t, srv, http.MethodPost, "/jobs/a/prioritize", "", &action); code != http.StatusOK {
+		t.Fatalf("prioritize = %d", code)
+	}
+	if got.Progress == nil || action.Progress == nil || *action.Progress != *got.Progress {
+		t.Errorf("progress = %+v from the action, %+v from GET", action.Progress, got.Progress)
+	}
+}
+
+func TestDeleteJob(t *testing.T) {
+	srv := newTestServer(t)
+	putJobs(t,
+		&jobs.Job{ID: "pending", Status: jobs.StatusPending},
+		&jobs.Job{ID: "paused", Status: jobs.StatusPaused},
+		&jobs.Job{ID: "done", Status: jobs.StatusCompleted},
+		&jobs.Job{ID: "release", Kind: jobs.KindCollection, Status: jobs.StatusFailed, Expanded: true, Children: []string{"a", "b"}},
+		&jobs.Job{ID: "a", Status: jobs.StatusFailed, ParentID: "release"},
+		&jobs.Job{ID: "b", Status: jobs.StatusPaused, ParentID: "release"},
+	)
+
+	tests := []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{method: http.MethodDelete, path: "/jobs/pending", code: http.StatusConflict},
+		{method: http.MethodDelete, path: "/jobs/paused", code: http.StatusConflict},
+		{method: http.MethodDelete, path: "/jobs/done", code: http.StatusNoContent},
+		{method: http.MethodGet, path: "/jobs/done", code: http.StatusNotFound},
+		{method: http.MethodDelete, path: "/jobs/done", code: http.StatusNotFound},
+		{method: http.MethodGet, path: "/jobs/pending", code: http.StatusOK},
+		// Nothing of a collection with an unfinished child is deleted
+		{method: http.MethodDelete, path: "/jobs/release", code: http.StatusConflict},
+		{method: http.MethodGet, path: "/jobs/release", code: http.StatusOK},
+		{method: http.MethodGet, path: "/jobs/a", code: http.StatusOK},
+	}
+	for _, tt := range tests {
+		if code := request(t, srv, tt.method, tt.path, "", nil); code != tt.code {
+			t.Errorf("%s %s = %d, want %d", tt.method, tt.path, code, tt.code)
+		}
+	}
+
+	if err := cancelJob("b"); err != nil {
+		t.Fatal(err)
+	}
+	if code := request(t, srv, http.MethodDelete, "/jobs/release", "", nil); code != http.StatusNoContent {
+		t.Fatalf("DELETE /jobs/release with finished children = %d, want %d", code, http.StatusNoContent)
+	}
+	for _, id := range []string{"release", "a", "b"} {
+		if _, ok := jobStore.Get(id); ok {
+			t.Errorf("job %s is left after deleting the collection", id)
+		}
+	}
+}
+
+// fileHost serves a file the way the download host does, except that a
+// download from the start stops halfway and hangs until the client gives
+// up. Only a Range request gets the rest of the file.
+type fileHost struct {
+	data   []byte
+	mutex  sync.Mutex
+	ranges []string
+}
+
+func (h *fileHost) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if value := r.Header.Get("Range"); value != "" {
+		h.mutex.Lock()
+		h.ranges = append(h.ranges, r.URL.Path+" "+value)
+		h.mutex.Unlock()
+		offset, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(value, "bytes="), "-"))
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, len(h.data)-1, len(h.data)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(h.data[offset:])
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(h.data)))
+	w.Write(h.data[:len(h.data)/2])
+	w.(http.Flusher).Flush()
+	<-r.Context().Done()
+}
+
+// catalogAPI logs in and answers the track, release and download URL
+// requests of the store API, every track being downloaded from host
+type catalogAPI struct {
+	host string
+}
+
+func (a catalogAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/v4")
+	switch {
+	case path == "/auth/login/":
+		http.SetCookie(w, &http.Cookie{Name: "sessionid", Value: "session"})
+		w.Write([]byte(`{}`))
+	case path == "/auth/o/authorize/":
+		w.Header().Set("Location", "https://www.beatport.com/?code=code")
+		w.WriteHeader(http.StatusFound)
+	case path == "/auth/o/token/":
+		w.Write([]byte(`{"access_token": "token", "refresh_token": "refresh", "expires_in": 3600}`))
+	case path == "/catalog/releases/5/":
+		w.Write([]byte(`{"id": 5, "name": "The EP", "slug": "the-ep", "catalog_number": "CAT001", "artists": [{"name": "One"}], "track_count": 2}`))
+	case strings.HasSuffix(path, "/download/"):
+		id := strings.TrimSuffix(strings.TrimPrefix(path, "/catalog/tracks/"), "/download/")
+		json.NewEncoder(w).Encode(map[string]string{"location": a.host + "/" + id, "stream_quality": ".flac"})
+	case strings.HasPrefix(path, "/catalog/tracks/"):
+		id := strings.Trim(strings.TrimPrefix(path, "/catalog/tracks/"), "/")
+		fmt.Fprintf(w, `{"id": %s, "name": "Song %s", "mix_name": "Original Mix", "slug": "song", "number": 1, "artists": [{"name": "One"}], "release": {"id": 5}}`, id, id)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// redirectTransport sends every request to a test server instead of the
+// store's API
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (t redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = t.target.Scheme
+	req.URL.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// startDownloads makes the test server download tracks from a fake store
+// API and file host, running a single job at a time
+func startDownloads(t *testing.T, host *fileHost) {
+	t.Helper()
+	files := httptest.NewServer(host)
+	t.Cleanup(files.Close)
+	api := httptest.NewServer(catalogAPI{host: files.URL})
+	t.Cleanup(api.Close)
+	target, _ := url.Parse(api.URL)
+
+	store := beatport.StoreBeatport
+	auths[store] = beatport.NewAuth("user", "secret", filepath.Join(t.TempDir(), "token.json"))
+	clients[store] = beatport.New(store, "", auths[store])
+	clients[store].SetTransport(redirectTransport{target: target})
+	t.Cleanup(func() {
+		delete(auths, store)
+		delete(clients, store)
+	})
+
+	dispatcher.Close()
+	dispatcher = jobs.NewDispatcher(1, processDownload)
+}
+
+// waitUntil polls cond until it holds, failing the test after a while
+func waitUntil(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitForPart waits until a job has written size bytes of its download
+// and returns the path of the download
+func waitForPart(t *testing.T, id string, size int) string {
+	t.Helper()
+	var path string
+	waitUntil(t, fmt.Sprintf("job %s wrote %d bytes", id, size), func() bool {
+		job, _ := jobStore.Get(id)
+		if job == nil || job.TempPath == "" {
+			return false
+		}
+		path = job.TempPath
+		info, err := os.Stat(downloader.PartPath(path))
+		return err == nil && info.Size() == int64(size)
+	})
+	return path
+}
+
+func waitForStatus(t *testing.T, id string, status jobs.Status) {
+	t.Helper()
+	waitUntil(t, fmt.Sprintf("job %s is %s", id, status), func() bool {
+		job, _ := jobStore.Get(id)
+		return job != nil && job.Status == status
+	})
+}
+
+func newFileHost() *fileHost {
+	return &fileHost{data: append([]byte("fLaC"), bytes.Repeat([]byte("audio"), 1<<14)...)}
+}
+
+func TestCancelRemovesDownload(t *testing.T) {
+	srv := newTestServer(t, "--tagging.enabled=false", "--cover.embed=false")
+	host := newFileHost()
+	startDownloads(t, host)
+	putJobs(t, &jobs.Job{ID: "a", Kind: jobs.KindTrack, TrackURL: "https://www.beatport.com/track/song/17", Status: jobs.StatusPending})
+	dispatcher.Enqueue("a")
+	path := waitForPart(t, "a", len(host.data)/2)
+
+	if code := request(t, srv, http.MethodPost, "/jobs/a/cancel", "", nil); code != http.StatusOK {
+		t.Fatalf("POST /jobs/a/cancel = %d, want %d", code, http.StatusOK)
+	}
+	if !waitForJob("a", 5*time.Second) {
+		t.Fatal("job a didn't stop")
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		t.Errorf("%s is left after cancelling", entry.Name())
+	}
+}
+
+func TestPauseAndResume(t *testing.T) {
+	srv := newTestServer(t, "--tagging.enabled=false", "--cover.embed=false")
+	host := newFileHost()
+	half := len(host.data) / 2
+	startDownloads(t, host)
+	putJobs(t,
+		&jobs.Job{ID: "a", Kind: jobs.KindTrack, TrackURL: "https://www.beatport.com/track/song/17", Status: jobs.StatusPending},
+		&jobs.Job{ID: "b", Kind: jobs.KindTrack, TrackURL: "https://www.beatport.com/track/song/18", Status: jobs.StatusPending},
+	)
+	dispatcher.Enqueue("a")
+	dispatcher.Enqueue("b")
+	path := waitForPart(t, "a", half)
+
+	// The only worker goes to the next job once the first one is paused
+	if code := request(t, srv, http.MethodPost, "/jobs/a/pause", "", nil); code != http.StatusOK {
+		t.Fatalf("POST /jobs/a/pause = %d, want %d", code, http.StatusOK)
+	}
+	waitForPart(t, "b", half)
+	if info, err := os.Stat(downloader.PartPath(path)); err != nil || info.Size() != int64(half) {
+		t.Fatalf("paused download: %v, want %d bytes kept", err, half)
+	}
+	if code := request(t, srv, http.MethodPost, "/jobs/b/cancel", "", nil); code != http.StatusOK {
+		t.Fatalf("POST /jobs/b/cancel = %d, want %d", code, http.StatusOK)
+	}
+
+	// Resuming asks only for the bytes that are missing
+	if code := request(t, srv, http.MethodPost, "/jobs/a/resume", "", nil); code != http.StatusOK {
+		t.Fatalf("POST /jobs/a/resume = %d, want %d", code, http.StatusOK)
+	}
+	waitForStatus(t, "a", jobs.StatusCompleted)
+	host.mutex.Lock()
+	ranges := host.ranges
+	host.mutex.Unlock()
+	if want := fmt.Sprintf("/17 bytes=%d-", half); len(ranges) != 1 || ranges[0] != want {
+		t.Errorf("range requests = %q, want %q", ranges, want)
+	}
+
+	job, _ := jobStore.Get("a")
+	finalPath, _ := job.Metadata["path"].(string)
+	if data, err := os.ReadFile(finalPath); err != nil || !bytes.Equal(data, host.data) {
+		t.Errorf("downloaded file %s: %v, want the whole file", finalPath, err)
+	}
+}
@@ -2,62 +2,193 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"html"
-	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
-	"regexp"
-	"sync"
+	"time"
 
 	"github.com/google/uuid"
-	"gopkg.in/yaml.v2"
 
 	"github.com/unspok3n/beatportdl-ui/config"
 	"github.com/unspok3n/beatportdl-ui/internal/beatport"
+	"github.com/unspok3n/beatportdl-ui/internal/jobs"
+	"github.com/unspok3n/beatportdl-ui/internal/pipeline"
+	"github.com/unspok3n/beatportdl-ui/internal/ratelimit"
 	"github.com/unspok3n/beatportdl-ui/internal/server"
+	"github.com/unspok3n/beatportdl-ui/internal/tagger"
+	"github.com/unspok3n/beatportdl-ui/internal/validator"
 )
 
-type downloadStatus struct {
-	TrackURL string                 `json:"track_url"`
-	Status   string                 `json:"status"`
-	Metadata map[string]interface{} `json:"metadata,omitempty"`
-}
+const jobPruneInterval = time.Hour
+
+var (
+	clients = make(map[beatport.Store]*beatport.Beatport)
+	// auths keep a separate login and token per store
+	auths = make(map[beatport.Store]*beatport.Auth)
+	// apiLimiters are the request limiters of the clients
+	apiLimiters = make(map[beatport.Store]*ratelimit.Limiter)
+	// bandwidthLimiter caps the combined speed of all downloads
+	bandwidthLimiter *ratelimit.Limiter
+	metadataCache    *beatport.Cache
+)
 
 var (
-	downloads         = make(map[string]*downloadStatus)
-	downloadsMutex    = &sync.Mutex{}
-	cfg               *config.AppConfig
-	downloadSemaphore chan struct{}
+	jobStore   *jobs.Store
+	dispatcher *jobs.Dispatcher
+	coverCache *tagger.CoverCache
 )
 
 func main() {
-	http.HandleFunc("/download", downloadHandler)
-	http.HandleFunc("/config", configureHandler)
-	http.HandleFunc("/status", statusHandler)
+	setup()
+	defer jobStore.Close()
+	defer dispatcher.Close()
+
+	requeueUnfinishedJobs()
+	go pruneJobs()
+	go watchConfig()
 
-	fmt.Println("Server listening on port 8080")
-	if err := http.ListenAndServe(":8080", nil); err != nil {
+	address := currentConfig().Server.Address
+	fmt.Printf("Server listening on %s\n", address)
+	if err := http.ListenAndServe(address, newMux()); err != nil {
 		fmt.Println("Error starting server:", err)
 	}
 }
 
-func init() {
-	var err error
-	cfg, err = config.Parse("./config.yml")
+// newMux returns the routes of the server
+func newMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/download", downloadHandler)
+	mux.HandleFunc("/config", configureHandler)
+	mux.HandleFunc("/config/sources", configSourcesHandler)
+	mux.HandleFunc("/config/schema", configSchemaHandler)
+	mux.HandleFunc("/status", statusHandler)
+	mux.HandleFunc("/auth", authHandler)
+	mux.HandleFunc("/cache", cacheHandler)
+	mux.HandleFunc("/search", searchHandler)
+	mux.HandleFunc("/events", eventsHandler)
+	mux.HandleFunc("/ws", wsHandler)
+	mux.HandleFunc("/jobs", jobsHandler)
+	mux.HandleFunc("/jobs/{id}", jobHandler)
+	mux.HandleFunc("/jobs/{id}/{action}", jobActionHandler)
+	return mux
+}
+
+// setup loads the configuration from the command line, the environment
+// and the config file, and opens the job store and the API clients.
+func setup() {
+	loaded, err := config.Load(os.Args[0], os.Args[1:], os.Environ())
 	if err != nil {
-		log.Printf("Error reading config: %v. Using default values.", err)
-		cfg = config.DefaultConfig()
-		if err := cfg.Save("./config.yml"); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		log.Fatalf("Error loading config: %v", err)
+	}
+	logConfigWarnings(loaded)
+	cfgLayers.Store(loaded)
+	cfg := loaded.Config
+	// Only the defaults are written, overrides from the environment or the
+	// command line stay out of the file
+	if _, err := os.Stat(loaded.Path); os.IsNotExist(err) {
+		if err := config.DefaultConfig().Save(loaded.Path); err != nil {
 			log.Printf("Failed to write default config: %v", err)
 		}
 	}
-	downloadSemaphore = make(chan struct{}, cfg.MaxDownloadWorkers)
+
+	jobStore, err = jobs.Open(cfg.Server.JobStorePath)
+	if err != nil {
+		log.Fatalf("Error opening job store: %v", err)
+	}
+	jobStore.OnChange(publishJobChange)
+	dispatcher = jobs.NewDispatcher(cfg.Downloads.Workers, processDownload)
+	coverCache = tagger.NewCoverCache(&http.Client{}, cfg.Cover.Size)
+
+	// A TTL of 0 turns the metadata cache off
+	if cfg.API.Cache.TTLHours > 0 {
+		metadataCache = beatport.NewCache(cfg.API.Cache.Directory, time.Duration(cfg.API.Cache.TTLHours)*time.Hour)
+	}
+	for _, store := range []beatport.Store{beatport.StoreBeatport, beatport.StoreBeatsource} {
+		auths[store] = beatport.NewAuth(cfg.Credentials.Username, cfg.Credentials.Password, cfg.Credentials.TokenCacheFile(string(store)))
+		clients[store] = beatport.New(store, cfg.Proxy, auths[store])
+		limit := cfg.API.RateLimits[string(store)]
+		apiLimiters[store] = ratelimit.New(limit.RequestsPerSecond, limit.Burst)
+		clients[store].SetLimiter(apiLimiters[store])
+		if metadataCache != nil {
+			clients[store].SetCache(metadataCache)
+		}
+	}
+	bandwidthLimiter = ratelimit.New(float64(cfg.Downloads.BandwidthLimit), int(cfg.Downloads.BandwidthLimit))
+	// The server starts listening while logging in, GET /auth reports
+	// when the login is done
+	go login()
+}
+
+// requeueUnfinishedJobs restarts every job that was pending or downloading
+// when the server last stopped.
+func requeueUnfinishedJobs() {
+	unfinished := jobStore.Unfinished()
+	if len(unfinished) == 0 {
+		return
+	}
+	log.Printf("Re-queueing %d unfinished download(s)", len(unfinished))
+
+	for _, job := range unfinished {
+		// Expanded collections finish through their children
+		if job.IsCollection() && job.Expanded {
+			continue
+		}
+		updated, err := jobStore.Update(job.ID, func(j *jobs.Job) {
+			j.Status = jobs.StatusPending
+		})
+		if err != nil {
+			log.Printf("Error re-queueing job %s: %v", job.ID, err)
+			continue
+		}
+		scheduleJob(updated)
+	}
+}
+
+// pruneJobs periodically removes finished jobs older than the configured
+// retention period.
+func pruneJobs() {
+	ticker := time.NewTicker(jobPruneInterval)
+	defer ticker.Stop()
+
+	for ; ; <-ticker.C {
+		cfg := currentConfig()
+		if cfg.Server.JobRetentionHours <= 0 {
+			continue
+		}
+		removed, err := jobStore.Prune(time.Duration(cfg.Server.JobRetentionHours) * time.Hour)
+		if err != nil {
+			log.Printf("Error pruning jobs: %v", err)
+			continue
+		}
+		if removed > 0 {
+			log.Printf("Pruned %d finished job(s)", removed)
+		}
+	}
+}
+
+// submittedTrack lists the jobs created for one track of a download request.
+type submittedTrack struct {
+	URL    string   `json:"url"`
+	ID     string   `json:"id"`
+	JobIDs []string `json:"job_ids"`
+}
+
+type downloadResponse struct {
+	Message string           `json:"message"`
+	Tracks  []submittedTrack `json:"tracks"`
+	Errors  []string         `json:"errors,omitempty"`
 }
 
 func downloadHandler(w http.ResponseWriter, r *http.Request) {
@@ -74,7 +205,8 @@ func downloadHandler(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	var data struct {
-		Tracks []map[string]interface{} `json:"tracks"`
+		Tracks  []map[string]interface{} `json:"tracks"`
+		Quality string                   `json:"quality"`
 	}
 	if err := json.Unmarshal(body, &data); err != nil {
 		http.Error(w, fmt.Sprintf("Error parsing JSON: %v", err), http.StatusBadRequest)
@@ -85,9 +217,36 @@ func downloadHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "No tracks provided", http.StatusBadRequest)
 		return
 	}
+	if data.Quality != "" && !validator.PermittedValue(data.Quality, config.SupportedQualities...) {
+		http.Error(w, fmt.Sprintf("Invalid quality: %s", data.Quality), http.StatusBadRequest)
+		return
+	}
 
+	submitted, errorMessages := submitTracks(data.Tracks, data.Quality)
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(submitted) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string][]string{"errors": errorMessages})
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(downloadResponse{
+		Message: "Download(s) initiated",
+		Tracks:  submitted,
+		Errors:  errorMessages,
+	})
+}
+
+// submitTracks creates a job for every submitted track or collection and
+// queues it. Tracks without a quality use defaultQuality, and the configured
+// quality when that is empty too. It returns the created jobs and a message
+// for every rejected entry.
+func submitTracks(tracks []map[string]interface{}, defaultQuality string) ([]submittedTrack, []string) {
 	errorMessages := make([]string, 0)
-	for _, track := range data.Tracks {
+	submitted := make([]submittedTrack, 0, len(tracks))
+	for _, track := range tracks {
 		id := uuid.New().String()
 		trackURL, urlOK := track["url"].(string)
 		if !urlOK {
@@ -100,66 +259,100 @@ func downloadHandler(w http.ResponseWriter, r *http.Request) {
 			errorMessages = append(errorMessages, fmt.Sprintf("Track: invalid URL format: %v", err))
 			continue
 		}
-		if parsedURL.Scheme != "https" || parsedURL.Host != "www.beatport.com" || !regexp.MustCompile(`^/(track|release)/`).MatchString(parsedURL.Path) {
-			errorMessages = append(errorMessages, "Track: invalid Beatport URL: scheme must be 'https', host must be 'www.beatport.com', and path must start with '/track/' or '/release/'")
+		if parsedURL.Scheme != "https" {
+			errorMessages = append(errorMessages, "Track: invalid URL: scheme must be 'https'")
+			continue
+		}
+		link, err := beatport.ParseUrl(trackURL)
+		if err != nil {
+			errorMessages = append(errorMessages, fmt.Sprintf("Track: invalid Beatport or Beatsource URL '%s': %v", trackURL, err))
 			continue
 		}
 
+		// Collections are described by the API, the extension only has to send their URL
+		kind := jobs.KindTrack
+		if link.Type != beatport.TrackLink {
+			kind = jobs.KindCollection
+		}
+
 		trackID, idOK := track["id"].(string)
-		if !idOK {
+		if !idOK && kind == jobs.KindTrack {
 			errorMessages = append(errorMessages, "Track: missing or invalid 'id'")
 			continue
 		}
 
 		title, titleOK := track["title"].(string)
-		if !titleOK {
+		if !titleOK && kind == jobs.KindTrack {
 			errorMessages = append(errorMessages, fmt.Sprintf("Track with id '%s': missing or invalid 'title'", trackID))
 			continue
 		}
 
 		artists, artistsOK := track["artists"].(string)
-		if !artistsOK {
+		if !artistsOK && kind == jobs.KindTrack {
 			errorMessages = append(errorMessages, fmt.Sprintf("Track with id '%s': missing or invalid 'artists'", trackID))
 			continue
 		}
 
-		downloadsMutex.Lock()
-		downloads[id] = &downloadStatus{
+		// A quality on the track overrides the one of the request
+		quality := defaultQuality
+		if trackQuality, ok := track["quality"].(string); ok && trackQuality != "" {
+			if !validator.PermittedValue(trackQuality, config.SupportedQualities...) {
+				errorMessages = append(errorMessages, fmt.Sprintf("Track with id '%s': invalid quality '%s'", trackID, trackQuality))
+				continue
+			}
+			quality = trackQuality
+		}
+
+		metadata := map[string]interface{}{}
+		if trackID != "" {
+			metadata["id"] = html.EscapeString(trackID)
+		}
+		if title != "" {
+			metadata["title"] = html.EscapeString(title)
+		}
+		if artists != "" {
+			metadata["artists"] = html.EscapeString(artists)
+		}
+
+		err = jobStore.Put(&jobs.Job{
+			ID:       id,
+			Kind:     kind,
 			TrackURL: parsedURL.String(),
-			Status:   "pending",
-			Metadata: map[string]interface{}{
-				"id":      html.EscapeString(trackID),
-				"title":   html.EscapeString(title),
-				"artists": html.EscapeString(artists),
-			},
+			Status:   jobs.StatusPending,
+			Quality:  quality,
+			Metadata: metadata,
+		})
+		if err != nil {
+			errorMessages = append(errorMessages, fmt.Sprintf("Track with id '%s': %v", trackID, err))
+			continue
 		}
-		downloadsMutex.Unlock()
 
-		downloadSemaphore <- struct{}{}
-		go processDownload(id, track)
-	}
+		submitted = append(submitted, submittedTrack{
+			URL:    parsedURL.String(),
+			ID:     trackID,
+			JobIDs: []string{id},
+		})
 
-	w.Header().Set("Content-Type", "application/json")
-	if len(errorMessages) > 0 {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string][]string{"errors": errorMessages})
-		return
+		dispatcher.Enqueue(id)
 	}
 
-	w.WriteHeader(http.StatusAccepted)
-	json.NewEncoder(w).Encode(map[string]string{"message": "Download(s) initiated"})
+	return submitted, errorMessages
 }
 
-func processDownloadInternal(track map[string]interface{}) (map[string]interface{}, error) {
+// storeClient returns the client for the given store, falling back to
+// Beatport for unknown stores.
+func storeClient(store beatport.Store) *beatport.Beatport {
+	if b, ok := clients[store]; ok {
+		return b
+	}
+	return clients[beatport.StoreBeatport]
+}
+
+func processDownloadInternal(ctx context.Context, cfg *config.AppConfig, job *jobs.Job) (map[string]interface{}, error) {
 	resp := map[string]interface{}{
-		"track":  track,
 		"status": "downloading",
 	}
-	trackURL, ok := track["url"].(string)
-	if !ok {
-		resp["status"] = "failed"
-		return resp, server.NewServerError(http.StatusBadRequest, "Invalid or missing URL in track data")
-	}
+	trackURL := job.TrackURL
 
 	link, err := beatport.ParseUrl(trackURL)
 	if err != nil {
@@ -174,135 +367,223 @@ func processDownloadInternal(track map[string]interface{}) (map[string]interface
 		return resp, server.NewServerError(http.StatusBadRequest, fmt.Sprintf("Unsupported link type: %s", link.Type))
 	}
 
-	b := beatport.New(link.Store, "", nil)
-	trackInfo, err := b.GetTrack(link.ID)
-	if err != nil {
-		resp["status"] = "failed"
-		return resp, server.NewServerError(http.StatusInternalServerError, fmt.Sprintf("Error getting track info: %v", err))
+	meter := jobs.NewMeter(progressInterval)
+	lastLoggedPercent := 0
+	p := &pipeline.Pipeline{
+		Config:    cfg,
+		Client:    storeClient(link.Store),
+		Covers:    coverCache,
+		Bandwidth: bandwidthLimiter,
+		Hooks: pipeline.Hooks{
+			Fallback: func(from, to string) {
+				log.Printf("Quality %s is not available for track %d, trying %s", from, link.ID, to)
+			},
+			Source: func(quality string, download *beatport.TrackDownload) {
+				log.Printf("Downloading track %d in %s quality from URL: %s", link.ID, quality, download.Location)
+				// Recorded right away, a resumed download reuses the URL
+				// without asking for the quality again
+				if _, err := jobStore.Update(job.ID, func(j *jobs.Job) {
+					if j.Metadata == nil {
+						j.Metadata = make(map[string]interface{})
+					}
+					j.Metadata["quality"] = quality
+					j.Metadata["stream_quality"] = download.StreamQuality
+				}); err != nil {
+					log.Printf("Error updating job %s: %v", job.ID, err)
+				}
+			},
+			Progress: func(written, total int64) {
+				progress, ok := meter.Update(written, total)
+				if !ok {
+					return
+				}
+				reportProgress(job.ID, progress)
+				if progress.Percent-lastLoggedPercent >= 10 {
+					lastLoggedPercent = progress.Percent
+					log.Printf("Download progress: %d%%", progress.Percent)
+				}
+			},
+			Logf: log.Printf,
+		},
 	}
 
-	downloadInfo, err := b.DownloadTrack(link.ID, "lossless")
+	result, err := p.Run(ctx, pipeline.Track{
+		Link:      link,
+		Directory: job.Directory,
+		TempPath:  job.TempPath,
+		Quality:   job.Quality,
+	})
 	if err != nil {
+		if ctx.Err() != nil {
+			return resp, context.Cause(ctx)
+		}
 		resp["status"] = "failed"
-		return resp, server.NewServerError(http.StatusInternalServerError, fmt.Sprintf("Error getting download URL: %v", err))
+		return resp, err
 	}
 
-	if downloadInfo == nil || downloadInfo.Location == "" {
-		resp["status"] = "failed"
-		return resp, server.NewServerError(http.StatusInternalServerError, "Empty download URL")
+	resp["status"] = "completed"
+	metadata := map[string]interface{}{"filename": filepath.Base(result.Path), "path": result.Path}
+	if result.Format != "" {
+		metadata["format"] = result.Format
 	}
-
-	downloadURL := downloadInfo.Location
-	log.Printf("Downloading from URL: %s", downloadURL)
-	httpClient := &http.Client{}
-	getResp, err := httpClient.Get(downloadURL)
-	if err != nil {
-		resp["status"] = "failed"
-		return resp, server.NewServerError(http.StatusInternalServerError, fmt.Sprintf("Error during download: %v", err))
+	if result.Skipped {
+		log.Printf("Skipping track %d, %s already exists", link.ID, result.Path)
+		metadata["skipped"] = true
+	} else {
+		log.Printf("Saved track %d to %s", link.ID, result.Path)
 	}
-	defer getResp.Body.Close()
+	resp["metadata"] = metadata
+	return resp, nil
+}
 
-	if getResp.StatusCode != http.StatusOK {
-		resp["status"] = "failed"
-		return resp, server.NewServerError(getResp.StatusCode, fmt.Sprintf("Download failed with status code: %d", getResp.StatusCode))
+// mergeMetadata adds the metadata of a processing result to the job,
+// keeping what was recorded on submission and while downloading.
+func mergeMetadata(j *jobs.Job, resp map[string]interface{}) {
+	metadata, ok := resp["metadata"].(map[string]interface{})
+	if !ok {
+		return
 	}
+	if j.Metadata == nil {
+		j.Metadata = make(map[string]interface{})
+	}
+	for key, value := range metadata {
+		j.Metadata[key] = value
+	}
+}
 
-	filename := fmt.Sprintf("%d.temp", trackInfo.ID)
-	tempDir := "/tmp"
-	filePath := filepath.Join(tempDir, filename)
-	outFile, err := os.Create(filePath)
-	if err != nil {
-		resp["status"] = "failed"
-		return resp, server.NewServerError(http.StatusInternalServerError, fmt.Sprintf("Error creating file: %v", err))
+// apiError wraps a Beatport API error for the job status. The status code
+// of the API is kept, so failures such as a missing track are not retried.
+func apiError(message string, err error) *server.ServerError {
+	code := http.StatusInternalServerError
+	var apiErr *beatport.ServerError
+	if errors.As(err, &apiErr) && apiErr.Code >= http.StatusBadRequest {
+		code = apiErr.Code
 	}
+	return server.NewServerError(code, fmt.Sprintf("%s: %v", message, err))
+}
 
-	contentLength := getResp.ContentLength
-	if contentLength <= 0 {
-		log.Printf("Content length is not available for %s, progress updates will not be provided.", downloadURL)
+// attemptError describes a failed attempt for the job status, keeping the
+// status code of the API or the file host.
+func attemptError(err error) *server.ServerError {
+	var serverErr *server.ServerError
+	if errors.As(err, &serverErr) {
+		return serverErr
 	}
+	code := http.StatusInternalServerError
+	if c, ok := pipeline.StatusCode(err); ok && c >= http.StatusBadRequest {
+		code = c
+	}
+	return server.NewServerError(code, err.Error())
+}
 
-	downloadedBytes := int64(0)
-	lastReportedPercent := 0
-	buf := make([]byte, 32*1024)
-	for {
-		n, err := getResp.Body.Read(buf)
-		if n > 0 {
-			written, err := outFile.Write(buf[:n])
-			if err != nil || written < n {
-				if err == nil {
-					err = fmt.Errorf("short write: wrote %d, expected %d", written, n)
-				}
-				resp["status"] = "failed"
-				return resp, server.NewServerError(http.StatusInternalServerError, fmt.Sprintf("Error writing to file: %v", err))
-			}
-			downloadedBytes += int64(n)
-
-			if contentLength > 0 {
-				percent := int(float64(downloadedBytes) / float64(contentLength) * 100)
-				if percent-lastReportedPercent >= 10 {
-					lastReportedPercent = percent
-					log.Printf("Download progress: %d%%", percent)
-					resp["progress"] = percent
-				}
+func processDownload(downloadID string) {
+	// The job keeps this snapshot even when the config is reloaded meanwhile
+	cfg := currentConfig()
+
+	// Jobs cancelled or paused while waiting for a free slot are skipped
+	started := false
+	job, err := jobStore.Update(downloadID, func(j *jobs.Job) {
+		if j.Status == jobs.StatusPending {
+			j.Status = jobs.StatusDownloading
+			j.NextRetryAt = nil
+			if j.TempPath == "" && !j.IsCollection() {
+				j.TempPath = jobTempPath(cfg, j.ID)
 			}
+			started = true
 		}
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			resp["status"] = "failed"
-			return resp, server.NewServerError(http.StatusInternalServerError, fmt.Sprintf("Error reading from response: %v", err))
-		}
+	})
+	if err != nil {
+		log.Printf("Warning: Download status not found for ID: %s: %v", downloadID, err)
+		return
+	}
+	if !started {
+		return
 	}
 
-	log.Printf("File tagging would be implemented here for: %s", filePath)
-
-	resp["status"] = "completed"
-	resp["metadata"] = map[string]interface{}{"filename": filename, "path": filePath}
-	return resp, nil
-}
-
-func processDownload(downloadID string, track map[string]interface{}) {
-	defer func() { <-downloadSemaphore }()
-
-	downloadsMutex.Lock()
-	status := downloads[downloadID]
-	if status == nil {
-		log.Printf("Warning: Download status not found for ID: %s", downloadID)
-		downloadsMutex.Unlock()
+	ctx, done := startJob(downloadID)
+	defer done()
+	// A pause or cancel between the status change and the registration
+	// found nothing to stop
+	if current, ok := jobStore.Get(downloadID); !ok || current.Status != jobs.StatusDownloading {
 		return
 	}
-	status.Status = "downloading"
-	downloadsMutex.Unlock()
+	if job.ParentID != "" {
+		defer refreshCollection(job.ParentID)
+	}
 
-	resp, err := processDownloadInternal(track)
+	var resp map[string]interface{}
+	if job.IsCollection() {
+		resp, err = processCollectionInternal(ctx, cfg, job)
+	} else {
+		resp, err = processDownloadInternal(ctx, cfg, job)
+	}
 	if err != nil {
-		log.Printf("processDownloadInternal error: %v", err)
-		if serverErr, ok := err.(*server.ServerError); ok {
-			status.Metadata["Code"] = serverErr.Code
-			status.Metadata["error"] = serverErr.Message
-		} else {
-			status.Metadata["error"] = err.Error()
+		switch cause := context.Cause(ctx); {
+		case errors.Is(cause, errJobCancelled):
+			removeJobFiles(job)
+			log.Printf("Download cancelled for %s", job.TrackURL)
+			return
+		case errors.Is(cause, errJobPaused):
+			log.Printf("Download paused for %s", job.TrackURL)
+			return
 		}
-		if metadata, ok := resp["metadata"].(map[string]interface{}); ok {
-			if _, ok := metadata["internal_error"]; !ok {
-				metadata["internal_error"] = err.Error()
+
+		log.Printf("processDownloadInternal error: %v", err)
+		retry := !job.IsCollection() && pipeline.Retryable(err)
+		job, updateErr := jobStore.Update(downloadID, func(j *jobs.Job) {
+			if j.Status != jobs.StatusDownloading {
+				retry = false
+				return
 			}
-		} else {
-			resp["metadata"] = map[string]interface{}{"internal_error": err.Error()}
+			mergeMetadata(j, resp)
+			if j.Metadata == nil {
+				j.Metadata = make(map[string]interface{})
+			}
+			jobErr := attemptError(err)
+			j.Metadata["code"] = jobErr.Code
+			j.Metadata["error"] = jobErr.Message
+			j.Attempts++
+			if retry && j.Attempts < cfg.Downloads.Retry.MaxAttempts {
+				next := time.Now().Add(cfg.RetryDelay(j.Attempts)).UTC()
+				j.NextRetryAt = &next
+				j.Status = jobs.StatusPending
+				return
+			}
+			retry = false
+			j.Status = jobs.StatusFailed
+		})
+		if updateErr != nil {
+			log.Printf("Error updating job %s: %v", downloadID, updateErr)
+			return
 		}
-		status.Status = "failed"
-		if metadata, ok := resp["metadata"].(map[string]interface{}); ok {
-			status.Metadata = metadata
+		if retry {
+			log.Printf("Download attempt %d of %d failed for %s, retrying at %s", job.Attempts, cfg.Downloads.Retry.MaxAttempts, job.TrackURL, job.NextRetryAt.Local().Format(time.TimeOnly))
+			scheduleJob(job)
+			return
 		}
-		log.Printf("Download failed for %s: %v", status.TrackURL, status.Metadata["error"])
-	} else {
-		status.Status = "completed"
-		if metadata, ok := resp["metadata"].(map[string]interface{}); ok {
-			status.Metadata = metadata
+		log.Printf("Download failed for %s: %v", job.TrackURL, job.Metadata["error"])
+		return
+	}
+
+	if job.IsCollection() {
+		refreshCollection(downloadID)
+		return
+	}
+
+	if _, err := jobStore.Update(downloadID, func(j *jobs.Job) {
+		if j.Status != jobs.StatusDownloading {
+			return
 		}
-		log.Printf("Download completed for %s", status.TrackURL)
+		mergeMetadata(j, resp)
+		// Errors of earlier attempts no longer apply
+		delete(j.Metadata, "code")
+		delete(j.Metadata, "error")
+		j.Status = jobs.StatusCompleted
+	}); err != nil {
+		log.Printf("Error updating job %s: %v", downloadID, err)
+		return
 	}
+	log.Printf("Download completed for %s", job.TrackURL)
 }
 
 func statusHandler(w http.ResponseWriter, r *http.Request) {
@@ -310,8 +591,10 @@ func statusHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	downloadsMutex.Lock()
-	defer downloadsMutex.Unlock()
+	downloads := make(map[string]jobStatus)
+	for _, job := range jobStore.List() {
+		downloads[job.ID] = withProgress(job)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(downloads); err != nil {
@@ -323,59 +606,3 @@ func statusHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Println("Returned download status")
 }
-
-func configureHandler(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		getConfig(w, r)
-	case http.MethodPut, http.MethodPost:
-		resp, err := updateConfig(r)
-		if err != nil {
-			code := http.StatusInternalServerError
-			if serverErr, ok := err.(*server.ServerError); ok {
-				code = serverErr.Code
-			}
-			w.WriteHeader(code)
-			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(resp)
-		log.Println("Configuration updated successfully")
-	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-	}
-}
-
-func getConfig(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(cfg)
-}
-
-func updateConfig(r *http.Request) (interface{}, error) {
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		log.Printf("Error reading config request body: %v", err)
-		return nil, server.NewServerError(http.StatusBadRequest, "Error reading request body")
-	}
-	defer r.Body.Close()
-
-	var newCfg config.AppConfig
-	if err := yaml.Unmarshal(body, &newCfg); err != nil {
-		return nil, server.NewServerError(http.StatusBadRequest, fmt.Sprintf("Error parsing config: %v", err))
-	}
-
-	if newCfg.MaxDownloadWorkers <= 0 {
-		return nil, server.NewServerError(http.StatusBadRequest, "Invalid max_concurrent_downloads value")
-	}
-
-	cfg.MaxDownloadWorkers = newCfg.MaxDownloadWorkers
-	downloadSemaphore = make(chan struct{}, cfg.MaxDownloadWorkers)
-
-	if err := cfg.Save("./config.yml"); err != nil {
-		return nil, server.NewServerError(http.StatusInternalServerError, fmt.Sprintf("Error writing config: %v", err))
-	}
-
-	return map[string]string{"status": "config updated"}, nil
-}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/unspok3n/beatportdl-ui/config"
+	"github.com/unspok3n/beatportdl-ui/internal/events"
+	"github.com/unspok3n/beatportdl-ui/internal/jobs"
+)
+
+// newTestServer points the server at a fresh job store and event broker
+// in a temporary directory and serves its routes. args are added to the
+// command line. Queued jobs are never run, so they stay in whatever state
+// a test puts them.
+func newTestServer(t *testing.T, args ...string) *httptest.Server {
+	t.Helper()
+	dir := t.TempDir()
+
+	loaded, err := config.Load("test", append([]string{
+		"--config", filepath.Join(dir, "config.yml"),
+		"--downloads.directory", filepath.Join(dir, "downloads"),
+		"--server.jobStorePath", filepath.Join(dir, "jobs.jsonl"),
+	}, args...), nil)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	cfgLayers.Store(loaded)
+
+	broker = events.NewBroker(eventHistorySize)
+	downloadProgress = make(map[string]jobs.Progress)
+	jobStore, err = jobs.Open(loaded.Config.Server.JobStorePath)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	jobStore.OnChange(publishJobChange)
+	dispatcher = jobs.NewDispatcher(1, func(string) {})
+
+	srv := httptest.NewServer(newMux())
+	t.Cleanup(func() {
+		srv.Close()
+		dispatcher.Close()
+		jobStore.Close()
+	})
+	return srv
+}
+
+// putJobs stores jobs created one second apart, in the given order
+func putJobs(t *testing.T, list ...*jobs.Job) {
+	t.Helper()
+	start := time.Now().Add(-time.Hour)
+	for i, job := range list {
+		job.CreatedAt = start.Add(time.Duration(i) * time.Second)
+		if err := jobStore.Put(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// request sends a request to the test server and decodes the JSON answer
+// into v, unless v is nil. It returns the status code.
+func request(t *testing.T, srv *httptest.Server, method, path string, body string, v interface{}) int {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil && resp.StatusCode < http.StatusBadRequest {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
@@ -0,0 +1,130 @@
+// cmd/server/reload.go
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/unspok3n/beatportdl-ui/config"
+	"github.com/unspok3n/beatportdl-ui/internal/events"
+	"github.com/unspok3n/beatportdl-ui/internal/validator"
+)
+
+const configPollInterval = 2 * time.Second
+
+// restartOptions are only read at startup, changing them while the server
+// runs has no effect until the next start
+var restartOptions = []string{
+	"server.address",
+	"server.jobStorePath",
+	"credentials.tokenCachePath",
+	"api.cache.directory",
+	"api.cache.ttlHours",
+	"cover.size",
+	"proxy",
+}
+
+var (
+	// cfgLayers holds the current configuration along with where each
+	// option came from. It is replaced as a whole on reload, never changed
+	// in place, so a job can keep using the snapshot it started with.
+	cfgLayers   atomic.Pointer[config.Loaded]
+	reloadMutex sync.Mutex
+)
+
+// currentConfig returns the configuration new work should use
+func currentConfig() *config.AppConfig {
+	return cfgLayers.Load().Config
+}
+
+// watchConfig applies every valid change of the config file until the
+// server stops.
+func watchConfig() {
+	cfgLayers.Load().Watch(context.Background(), configPollInterval, applyConfig, rejectConfig)
+}
+
+// reloadConfig reads the config file right away instead of waiting for
+// the watcher to notice the change.
+func reloadConfig() error {
+	next, err := cfgLayers.Load().Reload()
+	if err != nil {
+		rejectConfig(err)
+		return err
+	}
+	applyConfig(next)
+	return nil
+}
+
+// applyConfig makes next the current configuration and updates the parts
+// of the server that were set up from the previous one. Running jobs keep
+// the configuration they started with.
+func applyConfig(next *config.Loaded) {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	logConfigWarnings(next)
+	prev := cfgLayers.Load()
+	changed := config.Diff(prev.Config, next.Config)
+	cfgLayers.Store(next)
+	if len(changed) == 0 {
+		return
+	}
+
+	c := next.Config
+	restart := make([]string, 0)
+	for _, path := range changed {
+		switch {
+		case path == "downloads.workers":
+			dispatcher.Resize(c.Downloads.Workers)
+		case path == "downloads.bandwidthLimit":
+			bandwidthLimiter.SetLimit(float64(c.Downloads.BandwidthLimit), int(c.Downloads.BandwidthLimit))
+		case path == "api.rateLimits":
+			for store, limiter := range apiLimiters {
+				limit := c.API.RateLimits[string(store)]
+				limiter.SetLimit(limit.RequestsPerSecond, limit.Burst)
+			}
+		case validator.PermittedValue(path, restartOptions...):
+			restart = append(restart, path)
+		}
+	}
+	if validator.PermittedValue("credentials.username", changed...) || validator.PermittedValue("credentials.password", changed...) {
+		for _, auth := range auths {
+			auth.SetCredentials(c.Credentials.Username, c.Credentials.Password)
+		}
+		go login()
+	}
+
+	log.Printf("Configuration reloaded, changed: %s", strings.Join(changed, ", "))
+	if len(restart) > 0 {
+		log.Printf("Restart the server to apply: %s", strings.Join(restart, ", "))
+	}
+	broker.Publish(events.ConfigReloaded, "", map[string]interface{}{
+		"changed":          changed,
+		"restart_required": restart,
+	})
+}
+
+// logConfigWarnings logs the legacy keys migrated while loading the
+// config file
+func logConfigWarnings(l *config.Loaded) {
+	for _, warning := range l.Warnings {
+		log.Printf("Warning: %s: %s", l.Path, warning)
+	}
+}
+
+// rejectConfig reports a config file that failed to load. The previous
+// configuration stays in effect.
+func rejectConfig(err error) {
+	log.Printf("Ignoring config change, keeping the previous configuration: %v", err)
+	data := map[string]interface{}{"error": err.Error()}
+	var validationErrs config.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		data["errors"] = validationErrs
+	}
+	broker.Publish(events.ConfigRejected, "", data)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/unspok3n/beatportdl-ui/internal/beatport"
+	"github.com/unspok3n/beatportdl-ui/internal/events"
+	"github.com/unspok3n/beatportdl-ui/internal/ratelimit"
+)
+
+// limited reports whether a limiter holds back a second request made
+// right after the first
+func limited(l *ratelimit.Limiter) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	return l.Wait(ctx) != nil || l.Wait(ctx) != nil
+}
+
+// nextConfigEvent returns the next config event published on ch
+func nextConfigEvent(t *testing.T, ch <-chan events.Event) events.Event {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-ch:
+			if event.Type == events.ConfigReloaded || event.Type == events.ConfigRejected {
+				return event
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for the config to reload")
+		}
+	}
+}
+
+func TestWatchConfig(t *testing.T) {
+	newTestServer(t)
+	startDownloads(t, newFileHost())
+	store := beatport.StoreBeatport
+	bandwidthLimiter = ratelimit.New(0, 0)
+	apiLimiters[store] = ratelimit.New(0, 0)
+	t.Cleanup(func() {
+		bandwidthLimiter = nil
+		delete(apiLimiters, store)
+	})
+
+	if err := auths[store].Init(context.Background(), clients[store]); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	previousLogin := auths[store].Status().LoginID
+
+	_, _, ch, unsubscribe := broker.Subscribe(0)
+	defer unsubscribe()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go cfgLayers.Load().Watch(ctx, 10*time.Millisecond, applyConfig, rejectConfig)
+	// Changes made before the watcher looked at the file go unnoticed
+	time.Sleep(100 * time.Millisecond)
+
+	path := cfgLayers.Load().Path
+	document := `
+downloads:
+  workers: 5
+  bandwidthLimit: 1
+api:
+  rateLimits:
+    beatport: {requestsPerSecond: 1, burst: 1}
+credentials:
+  username: other
+  password: secret
+server:
+  address: ":9090"
+`
+	if err := os.WriteFile(path, []byte(document), 0644); err != nil {
+		t.Fatal(err)
+	}
+	event := nextConfigEvent(t, ch)
+	if event.Type != events.ConfigReloaded {
+		t.Fatalf("event = %+v, want %s", event, events.ConfigReloaded)
+	}
+	data := event.Data.(map[string]interface{})
+	changed := strings.Join(data["changed"].([]string), " ")
+	for _, path := range []string{"downloads.workers", "downloads.bandwidthLimit", "api.rateLimits", "credentials.username", "server.address"} {
+		if !strings.Contains(changed, path) {
+			t.Errorf("changed = %s, want %s among them", changed, path)
+		}
+	}
+	if restart := data["restart_required"].([]string); len(restart) != 1 || restart[0] != "server.address" {
+		t.Errorf("restart_required = %v, want server.address", restart)
+	}
+
+	if cfg := currentConfig(); cfg.Downloads.Workers != 5 || cfg.Server.Address != ":9090" {
+		t.Errorf("current config has %d workers and address %s", cfg.Downloads.Workers, cfg.Server.Address)
+	}
+	if size := dispatcher.Size(); size != 5 {
+		t.Errorf("dispatcher size = %d, want 5", size)
+	}
+	if !limited(bandwidthLimiter) {
+		t.Error("bandwidth limit not applied")
+	}
+	if !limited(apiLimiters[store]) {
+		t.Error("API rate limit not applied")
+	}
+	// The new credentials log in again in the background
+	waitUntil(t, "logged in with the new credentials", func() bool {
+		loginMutex.Lock()
+		loggingIn := logins[store].LoggingIn
+		loginMutex.Unlock()
+		status := auths[store].Status()
+		return !loggingIn && status.LoggedIn && status.LoginID != previousLogin
+	})
+
+	// An invalid file is reported and the previous settings stay
+	if err := os.WriteFile(path, []byte("downloads:\n  workers: 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if event := nextConfigEvent(t, ch); event.Type != events.ConfigRejected {
+		t.Errorf("event = %+v, want %s", event, events.ConfigRejected)
+	}
+	if workers := currentConfig().Downloads.Workers; workers != 5 || dispatcher.Size() != 5 {
+		t.Errorf("%d workers after an invalid change, want 5", workers)
+	}
+}
@@ -0,0 +1,120 @@
+// cmd/server/search.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/unspok3n/beatportdl-ui/config"
+	"github.com/unspok3n/beatportdl-ui/internal/beatport"
+	"github.com/unspok3n/beatportdl-ui/internal/server"
+	"github.com/unspok3n/beatportdl-ui/internal/validator"
+)
+
+// searchHandler searches the catalog of the store given in the "store"
+// query parameter, Beatport by default. Filters are passed as query
+// parameters and map one to one onto beatport.SearchOptions.
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	store := beatport.StoreBeatport
+	if value := query.Get("store"); value != "" {
+		if !validator.PermittedValue(value, config.SupportedStores...) {
+			writeError(w, server.NewServerError(http.StatusBadRequest, fmt.Sprintf("Invalid store: %s", value)))
+			return
+		}
+		store = beatport.Store(value)
+	}
+
+	opts, err := searchOptions(query)
+	if err == nil {
+		err = opts.Validate()
+	}
+	if err != nil {
+		writeError(w, server.NewServerError(http.StatusBadRequest, fmt.Sprintf("Invalid search: %v", err)))
+		return
+	}
+
+	results, err := storeClient(store).Search(r.Context(), opts)
+	if err != nil {
+		writeError(w, apiError("Error searching catalog", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+func searchOptions(query url.Values) (beatport.SearchOptions, error) {
+	opts := beatport.SearchOptions{
+		Query:   query.Get("q"),
+		Type:    beatport.SearchType(query.Get("type")),
+		OrderBy: query.Get("order_by"),
+	}
+
+	if value := query.Get("include_unstreamable"); value != "" {
+		include, err := strconv.ParseBool(value)
+		if err != nil {
+			return opts, fmt.Errorf("include_unstreamable must be true or false")
+		}
+		opts.IncludeUnstreamable = include
+	}
+
+	ints := map[string]*int{
+		"bpm_min":  &opts.MinBPM,
+		"bpm_max":  &opts.MaxBPM,
+		"page":     &opts.Page,
+		"per_page": &opts.PerPage,
+	}
+	for name, dst := range ints {
+		if value := query.Get(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return opts, fmt.Errorf("%s must be a number", name)
+			}
+			*dst = n
+		}
+	}
+
+	ids := map[string]*int64{
+		"genre_id":    &opts.GenreID,
+		"subgenre_id": &opts.SubgenreID,
+		"key_id":      &opts.KeyID,
+		"label_id":    &opts.LabelID,
+		"artist_id":   &opts.ArtistID,
+	}
+	for name, dst := range ids {
+		if value := query.Get(name); value != "" {
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || id < 1 {
+				return opts, fmt.Errorf("%s must be a positive number", name)
+			}
+			*dst = id
+		}
+	}
+
+	dates := map[string]*time.Time{
+		"released_after":  &opts.ReleasedAfter,
+		"released_before": &opts.ReleasedBefore,
+	}
+	for name, dst := range dates {
+		if value := query.Get(name); value != "" {
+			date, err := time.Parse(beatport.SearchDateLayout, value)
+			if err != nil {
+				return opts, fmt.Errorf("%s must be a date like %s", name, beatport.SearchDateLayout)
+			}
+			*dst = date
+		}
+	}
+
+	if opts.Query == "" && opts.GenreID == 0 && opts.LabelID == 0 && opts.ArtistID == 0 {
+		return opts, errors.New("a query or a genre, label or artist filter is required")
+	}
+	return opts, nil
+}
@@ -0,0 +1,390 @@
+// cmd/server/ws.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/unspok3n/beatportdl-ui/config"
+	"github.com/unspok3n/beatportdl-ui/internal/events"
+	"github.com/unspok3n/beatportdl-ui/internal/jobs"
+	"github.com/unspok3n/beatportdl-ui/internal/server"
+	"github.com/unspok3n/beatportdl-ui/internal/validator"
+)
+
+// wsProtocolVersion is the version of the message protocol spoken on /ws.
+// Clients send the version they speak with every message; messages without
+// one are treated as version 1. Newer versions only add message types and
+// fields, so older clients keep working with whatever they know about.
+const wsProtocolVersion = 1
+
+const (
+	wsWriteTimeout  = 10 * time.Second
+	wsPongTimeout   = 60 * time.Second
+	wsPingInterval  = 45 * time.Second
+	wsMaxMessage    = 1 << 20
+	wsOutboundQueue = 256
+)
+
+// Message types sent by the server
+const (
+	wsHello  = "hello"
+	wsResult = "result"
+	wsError  = "error"
+	wsEvent  = "event"
+	wsReset  = "reset"
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	CheckOrigin:     wsAllowedOrigin,
+}
+
+// wsRequest is a message sent by the client. ID is chosen by the client
+// and echoed in the reply.
+type wsRequest struct {
+	Version int                      `json:"v"`
+	ID      string                   `json:"id"`
+	Type    string                   `json:"type"`
+	Tracks  []map[string]interface{} `json:"tracks,omitempty"`
+	Quality string                   `json:"quality,omitempty"`
+	JobIDs  []string                 `json:"job_ids,omitempty"`
+	All     bool                     `json:"all,omitempty"`
+}
+
+type wsMessage struct {
+	Version  int           `json:"v"`
+	Type     string        `json:"type"`
+	ID       string        `json:"id,omitempty"`
+	Data     interface{}   `json:"data,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Code     int           `json:"code,omitempty"`
+	Event    *events.Event `json:"event,omitempty"`
+	Versions []int         `json:"versions,omitempty"`
+}
+
+// wsJobResult is the outcome of an action on a single job.
+type wsJobResult struct {
+	JobID string `json:"job_id"`
+	Error string `json:"error,omitempty"`
+	Code  int    `json:"code,omitempty"`
+}
+
+// wsAllowedOrigin accepts the browser extension, same-origin pages and
+// non-browser clients, so arbitrary websites can't drive the server.
+func wsAllowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	switch u.Scheme {
+	case "chrome-extension", "moz-extension", "safari-web-extension":
+		return true
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// wsClient is a single WebSocket connection. Every write goes through the
+// outbound queue, which is drained by a single writer.
+type wsClient struct {
+	conn     *websocket.Conn
+	outbound chan wsMessage
+	done     chan struct{}
+	closing  sync.Once
+
+	// all is set when the client subscribed to every job, otherwise only
+	// events of the jobs in subscribed and their children are sent
+	all        bool
+	subscribed map[string]bool
+	lastID     uint64
+	mutex      sync.Mutex
+}
+
+func wsHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already answered the request
+		log.Printf("Error upgrading WebSocket connection: %v", err)
+		return
+	}
+
+	c := &wsClient{
+		conn:       conn,
+		outbound:   make(chan wsMessage, wsOutboundQueue),
+		done:       make(chan struct{}),
+		subscribed: make(map[string]bool),
+	}
+	// Subscribed before reading any message so no event of a submitted job
+	// can slip through
+	_, _, ch, unsubscribe := broker.Subscribe(0)
+	go c.writeLoop()
+	go c.forwardEvents(ch, unsubscribe)
+
+	c.send(wsMessage{Type: wsHello, Versions: []int{wsProtocolVersion}})
+	c.readLoop()
+}
+
+func (c *wsClient) close() {
+	c.closing.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// send queues a message. A client that stops reading is disconnected
+// rather than blocking the server.
+func (c *wsClient) send(msg wsMessage) {
+	msg.Version = wsProtocolVersion
+	select {
+	case c.outbound <- msg:
+	case <-c.done:
+	default:
+		log.Println("WebSocket client is not keeping up, closing connection")
+		c.close()
+	}
+}
+
+func (c *wsClient) readLoop() {
+	defer c.close()
+
+	c.conn.SetReadLimit(wsMaxMessage)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("WebSocket read error: %v", err)
+			}
+			return
+		}
+		c.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+
+		var req wsRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			c.send(wsMessage{Type: wsError, Code: http.StatusBadRequest, Error: fmt.Sprintf("Invalid message: %v", err)})
+			continue
+		}
+		c.handle(&req)
+	}
+}
+
+func (c *wsClient) writeLoop() {
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	defer c.close()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case msg := <-c.outbound:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := c.conn.WriteJSON(msg); err != nil {
+				return
+			}
+		case <-ping.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *wsClient) handle(req *wsRequest) {
+	if req.Version == 0 {
+		req.Version = 1
+	}
+	if req.Version > wsProtocolVersion {
+		c.reply(req, nil, server.NewServerError(http.StatusBadRequest, fmt.Sprintf("Unsupported protocol version %d", req.Version)))
+		return
+	}
+
+	switch req.Type {
+	case "ping":
+		c.reply(req, map[string]int64{"time": time.Now().Unix()}, nil)
+	case "submit":
+		c.submit(req)
+	case "subscribe":
+		c.subscribe(req)
+	case "unsubscribe":
+		c.mutex.Lock()
+		if req.All {
+			c.all = false
+			c.subscribed = make(map[string]bool)
+		}
+		for _, id := range req.JobIDs {
+			delete(c.subscribed, id)
+		}
+		c.mutex.Unlock()
+		c.reply(req, nil, nil)
+	default:
+		apply, ok := jobActions[req.Type]
+		if !ok {
+			c.reply(req, nil, server.NewServerError(http.StatusBadRequest, fmt.Sprintf("Unknown message type: %s", req.Type)))
+			return
+		}
+		if len(req.JobIDs) == 0 {
+			c.reply(req, nil, server.NewServerError(http.StatusBadRequest, "No job IDs provided"))
+			return
+		}
+		results := make([]wsJobResult, 0, len(req.JobIDs))
+		for _, id := range req.JobIDs {
+			result := wsJobResult{JobID: id}
+			if err := apply(id); err != nil {
+				result.Error = err.Error()
+				if serverErr, ok := err.(*server.ServerError); ok {
+					result.Error = serverErr.Message
+					result.Code = serverErr.Code
+				}
+			}
+			results = append(results, result)
+		}
+		c.reply(req, results, nil)
+	}
+}
+
+func (c *wsClient) reply(req *wsRequest, data interface{}, err error) {
+	if err == nil {
+		c.send(wsMessage{Type: wsResult, ID: req.ID, Data: data})
+		return
+	}
+	msg := wsMessage{Type: wsError, ID: req.ID, Error: err.Error(), Code: http.StatusInternalServerError}
+	if serverErr, ok := err.(*server.ServerError); ok {
+		msg.Error = serverErr.Message
+		msg.Code = serverErr.Code
+	}
+	c.send(msg)
+}
+
+func (c *wsClient) submit(req *wsRequest) {
+	if len(req.Tracks) == 0 {
+		c.reply(req, nil, server.NewServerError(http.StatusBadRequest, "No tracks provided"))
+		return
+	}
+	if req.Quality != "" && !validator.PermittedValue(req.Quality, config.SupportedQualities...) {
+		c.reply(req, nil, server.NewServerError(http.StatusBadRequest, fmt.Sprintf("Invalid quality: %s", req.Quality)))
+		return
+	}
+
+	// Submitted jobs are followed right away. The lock holds back the event
+	// forwarder until then, so not even the creation events are missed.
+	c.mutex.Lock()
+	submitted, errorMessages := submitTracks(req.Tracks, req.Quality)
+	for _, track := range submitted {
+		for _, id := range track.JobIDs {
+			c.subscribed[id] = true
+		}
+	}
+	c.mutex.Unlock()
+
+	if len(submitted) == 0 {
+		c.send(wsMessage{Type: wsError, ID: req.ID, Code: http.StatusBadRequest, Error: strings.Join(errorMessages, "; ")})
+		return
+	}
+	c.reply(req, downloadResponse{
+		Message: "Download(s) initiated",
+		Tracks:  submitted,
+		Errors:  errorMessages,
+	}, nil)
+}
+
+// subscribe follows the given jobs, or every job when All is set, and
+// replies with their current state.
+func (c *wsClient) subscribe(req *wsRequest) {
+	if !req.All && len(req.JobIDs) == 0 {
+		c.reply(req, nil, server.NewServerError(http.StatusBadRequest, "No job IDs provided"))
+		return
+	}
+
+	c.mutex.Lock()
+	if req.All {
+		c.all = true
+	}
+	for _, id := range req.JobIDs {
+		c.subscribed[id] = true
+	}
+	c.mutex.Unlock()
+
+	states := make([]jobStatus, 0, len(req.JobIDs))
+	for _, id := range req.JobIDs {
+		if job, ok := jobStore.Get(id); ok {
+			states = append(states, withProgress(job))
+		}
+	}
+	c.reply(req, states, nil)
+}
+
+// wants reports whether an event belongs to a job the client follows.
+// Children of followed collections are followed as soon as they are
+// created. Events that aren't about a job, like config reloads, go to
+// every client.
+func (c *wsClient) wants(event events.Event) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.all || event.JobID == "" || c.subscribed[event.JobID] {
+		return true
+	}
+	if job, ok := event.Data.(*jobs.Job); ok && event.Type == events.JobCreated && c.subscribed[job.ParentID] {
+		c.subscribed[job.ID] = true
+		return true
+	}
+	return false
+}
+
+// forwardEvents relays broker events the client is subscribed to. When the
+// client falls behind the broker, it resubscribes from the last event it
+// forwarded and tells the client if events were lost.
+func (c *wsClient) forwardEvents(ch <-chan events.Event, unsubscribe func()) {
+	for {
+		for open := true; open; {
+			select {
+			case <-c.done:
+				unsubscribe()
+				return
+			case event, ok := <-ch:
+				if !ok {
+					open = false
+					break
+				}
+				c.forward(event)
+			}
+		}
+		unsubscribe()
+
+		var missed []events.Event
+		var complete bool
+		missed, complete, ch, unsubscribe = broker.Subscribe(c.lastID)
+		if !complete {
+			c.send(wsMessage{Type: wsReset})
+		}
+		for _, event := range missed {
+			c.forward(event)
+		}
+	}
+}
+
+func (c *wsClient) forward(event events.Event) {
+	c.lastID = event.ID
+	if c.wants(event) {
+		c.send(wsMessage{Type: wsEvent, Event: &event})
+	}
+}
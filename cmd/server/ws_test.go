@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/unspok3n/beatportdl-ui/internal/events"
+	"github.com/unspok3n/beatportdl-ui/internal/jobs"
+)
+
+// wsTestMessage is a server message with its data left undecoded
+type wsTestMessage struct {
+	Type     string          `json:"type"`
+	ID       string          `json:"id"`
+	Data     json.RawMessage `json:"data"`
+	Error    string          `json:"error"`
+	Code     int             `json:"code"`
+	Event    *events.Event   `json:"event"`
+	Versions []int           `json:"versions"`
+}
+
+func dialWS(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	if msg := readWS(t, conn); msg.Type != wsHello || len(msg.Versions) == 0 {
+		t.Fatalf("first message = %+v, want %s", msg, wsHello)
+	}
+	return conn
+}
+
+func readWS(t *testing.T, conn *websocket.Conn) wsTestMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var msg wsTestMessage
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("ReadJSON: %v", err)
+	}
+	return msg
+}
+
+// replyTo reads messages until the reply to the request with the given ID,
+// collecting the events received on the way
+func replyTo(t *testing.T, conn *websocket.Conn, id string, seen *[]events.Event) wsTestMessage {
+	t.Helper()
+	for {
+		msg := readWS(t, conn)
+		if msg.Type == wsEvent && seen != nil {
+			*seen = append(*seen, *msg.Event)
+		}
+		if msg.ID == id && (msg.Type == wsResult || msg.Type == wsError) {
+			return msg
+		}
+	}
+}
+
+func TestWSSubmitAndSubscribe(t *testing.T) {
+	srv := newTestServer(t)
+	putJobs(t, &jobs.Job{ID: "other", Status: jobs.StatusPending})
+	conn := dialWS(t, srv.URL)
+
+	err := conn.WriteJSON(map[string]interface{}{
+		"v": 1, "id": "1", "type": "submit",
+		"tracks": []map[string]string{{"url": "https://www.beatport.com/track/song/17", "id": "17", "title": "Song", "artists": "One"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seen []events.Event
+	reply := replyTo(t, conn, "1", &seen)
+	var submitted downloadResponse
+	if err := json.Unmarshal(reply.Data, &submitted); err != nil || reply.Type != wsResult || len(submitted.Tracks) != 1 {
+		t.Fatalf("submit reply = %+v", reply)
+	}
+	jobID := submitted.Tracks[0].JobIDs[0]
+
+	// The submitted job is followed, other jobs are not
+	putJobs(t, &jobs.Job{ID: "unrelated", Status: jobs.StatusPending})
+	if err := conn.WriteJSON(map[string]interface{}{"id": "2", "type": "pause", "job_ids": []string{jobID, "missing"}}); err != nil {
+		t.Fatal(err)
+	}
+	reply = replyTo(t, conn, "2", &seen)
+	var results []wsJobResult
+	if err := json.Unmarshal(reply.Data, &results); err != nil || len(results) != 2 {
+		t.Fatalf("pause reply = %+v", reply)
+	}
+	if results[0].Error != "" || results[1].Code != http.StatusNotFound {
+		t.Errorf("pause results = %+v, want success and %d", results, http.StatusNotFound)
+	}
+
+	// The status change follows the reply
+	for len(seen) < 2 {
+		if msg := readWS(t, conn); msg.Type == wsEvent {
+			seen = append(seen, *msg.Event)
+		}
+	}
+	for _, event := range seen {
+		if event.JobID != jobID {
+			t.Errorf("got event %s of job %s, only %s is followed", event.Type, event.JobID, jobID)
+		}
+	}
+	if seen[0].Type != events.JobCreated || seen[1].Type != events.JobStatus {
+		t.Errorf("events = %s, %s; want %s, %s", seen[0].Type, seen[1].Type, events.JobCreated, events.JobStatus)
+	}
+
+	if err := conn.WriteJSON(map[string]interface{}{"id": "3", "type": "subscribe", "job_ids": []string{"other"}}); err != nil {
+		t.Fatal(err)
+	}
+	reply = replyTo(t, conn, "3", nil)
+	var states []jobStatus
+	if err := json.Unmarshal(reply.Data, &states); err != nil || len(states) != 1 || states[0].ID != "other" {
+		t.Fatalf("subscribe reply = %+v", reply)
+	}
+	if err := cancelJob("other"); err != nil {
+		t.Fatal(err)
+	}
+	if msg := readWS(t, conn); msg.Type != wsEvent || msg.Event.JobID != "other" || msg.Event.Type != events.JobStatus {
+		t.Errorf("message after subscribing = %+v, want the status of other", msg)
+	}
+}
+
+func TestWSErrors(t *testing.T) {
+	srv := newTestServer(t)
+	conn := dialWS(t, srv.URL)
+
+	tests := []struct {
+		request string
+		code    int
+	}{
+		{request: `{"id": "1", "type": "explode"}`, code: http.StatusBadRequest},
+		{request: `{"id": "2", "v": 99, "type": "ping"}`, code: http.StatusBadRequest},
+		{request: `{"id": "3", "type": "submit"}`, code: http.StatusBadRequest},
+		{request: `{"id": "4", "type": "submit", "tracks": [{"url": "http://example.com"}]}`, code: http.StatusBadRequest},
+		{request: `{"id": "5", "type": "cancel"}`, code: http.StatusBadRequest},
+		{request: `{"id": "6", "type": "subscribe"}`, code: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(tt.request)); err != nil {
+			t.Fatal(err)
+		}
+		var id struct {
+			ID string `json:"id"`
+		}
+		json.Unmarshal([]byte(tt.request), &id)
+		if msg := replyTo(t, conn, id.ID, nil); msg.Type != wsError || msg.Code != tt.code {
+			t.Errorf("%s: reply = %+v, want error %d", tt.request, msg, tt.code)
+		}
+	}
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"id": "7", "type": "ping"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if msg := replyTo(t, conn, "7", nil); msg.Type != wsResult {
+		t.Errorf("ping reply = %+v", msg)
+	}
+}
+
+func TestWSRejectsForeignOrigin(t *testing.T) {
+	srv := newTestServer(t)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+
+	_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://example.com"}})
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Dial from a foreign origin = %v, want %d", err, http.StatusForbidden)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"chrome-extension://abc"}})
+	if err != nil {
+		t.Fatalf("Dial from the extension: %v", err)
+	}
+	conn.Close()
+}
@@ -1,93 +1,505 @@
 package config
 
 import (
-	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v2"
+
+	"github.com/unspok3n/beatportdl-ui/internal/beatport"
+	"github.com/unspok3n/beatportdl-ui/internal/library"
+	"github.com/unspok3n/beatportdl-ui/internal/validator"
 )
 
-// AppConfig holds the application configuration
+// AppConfig holds the application configuration. Every section maps to a
+// top-level key of config.yml.
 type AppConfig struct {
-	MaxGlobalWorkers   int `json:"maxGlobalWorkers" yaml:"maxGlobalWorkers"`
-	MaxDownloadWorkers int `json:"maxDownloadWorkers" yaml:"maxDownloadWorkers"`
+	Credentials Credentials `json:"credentials" yaml:"credentials"`
+	Downloads   Downloads   `json:"downloads" yaml:"downloads"`
+	Naming      Naming      `json:"naming" yaml:"naming"`
+	Tagging     Tagging     `json:"tagging" yaml:"tagging"`
+	Cover       Cover       `json:"cover" yaml:"cover"`
+	Server      Server      `json:"server" yaml:"server"`
+	API         API         `json:"api" yaml:"api"`
+	// Proxy is the URL of the proxy used for every request, empty for none
+	Proxy string `json:"proxy" yaml:"proxy"`
+}
+
+// Credentials is the Beatport account used to log in. The username and
+// password are left out of JSON, so the API never echoes them back.
+type Credentials struct {
+	Username       string `json:"-" yaml:"username"`
+	Password       string `json:"-" yaml:"password"`
+	TokenCachePath string `json:"tokenCachePath" yaml:"tokenCachePath"`
+}
+
+type Downloads struct {
+	Directory string `json:"directory" yaml:"directory"`
+	Workers   int    `json:"workers" yaml:"workers"`
+	Quality   string `json:"quality" yaml:"quality"`
+	// QualityFallback tries the next lower quality when the requested one
+	// isn't available
+	QualityFallback  bool   `json:"qualityFallback" yaml:"qualityFallback"`
+	SortByContext    bool   `json:"sortByContext" yaml:"sortByContext"`
+	FileExistsPolicy string `json:"fileExistsPolicy" yaml:"fileExistsPolicy"`
+	// BandwidthLimit caps the combined speed of all downloads in bytes per
+	// second, 0 disables it
+	BandwidthLimit int64 `json:"bandwidthLimit" yaml:"bandwidthLimit"`
+	Retry          Retry `json:"retry" yaml:"retry"`
+}
+
+type Retry struct {
+	MaxAttempts      int     `json:"maxAttempts" yaml:"maxAttempts"`
+	BaseDelaySeconds int     `json:"baseDelaySeconds" yaml:"baseDelaySeconds"`
+	MaxDelaySeconds  int     `json:"maxDelaySeconds" yaml:"maxDelaySeconds"`
+	Jitter           float64 `json:"jitter" yaml:"jitter"`
+}
+
+// Naming holds the templates for file and directory names. A
+// TrackNumberPadding of 0 pads track numbers to the width of the track count.
+type Naming struct {
+	TrackTemplate       string `json:"trackTemplate" yaml:"trackTemplate"`
+	ReleaseTemplate     string `json:"releaseTemplate" yaml:"releaseTemplate"`
+	PlaylistTemplate    string `json:"playlistTemplate" yaml:"playlistTemplate"`
+	ChartTemplate       string `json:"chartTemplate" yaml:"chartTemplate"`
+	LabelTemplate       string `json:"labelTemplate" yaml:"labelTemplate"`
+	ArtistTemplate      string `json:"artistTemplate" yaml:"artistTemplate"`
+	WhitespaceCharacter string `json:"whitespaceCharacter" yaml:"whitespaceCharacter"`
+	ArtistsLimit        int    `json:"artistsLimit" yaml:"artistsLimit"`
+	ArtistsShortForm    string `json:"artistsShortForm" yaml:"artistsShortForm"`
+	TrackNumberPadding  int    `json:"trackNumberPadding" yaml:"trackNumberPadding"`
+	KeySystem           string `json:"keySystem" yaml:"keySystem"`
+}
+
+type Tagging struct {
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	// Mappings maps a file format to the tag written for each field. A
+	// format listed here replaces the default mappings for that format only.
+	Mappings map[string]map[string]string `json:"mappings" yaml:"mappings"`
+}
+
+type Cover struct {
+	Size  string `json:"size" yaml:"size"`
+	Embed bool   `json:"embed" yaml:"embed"`
+	Keep  bool   `json:"keep" yaml:"keep"`
+}
+
+type Server struct {
+	Address           string `json:"address" yaml:"address"`
+	JobStorePath      string `json:"jobStorePath" yaml:"jobStorePath"`
+	JobRetentionHours int    `json:"jobRetentionHours" yaml:"jobRetentionHours"`
+}
+
+// API configures how the Beatport and Beatsource APIs are called
+type API struct {
+	// RateLimits maps a store to the limit shared by all its API requests
+	RateLimits map[string]RateLimit `json:"rateLimits" yaml:"rateLimits"`
+	Cache      MetadataCache        `json:"cache" yaml:"cache"`
+}
+
+// MetadataCache keeps catalog lookups on disk, a TTLHours of 0 disables it
+type MetadataCache struct {
+	Directory string `json:"directory" yaml:"directory"`
+	TTLHours  int    `json:"ttlHours" yaml:"ttlHours"`
+}
+
+// RateLimit is a token bucket limit, a RequestsPerSecond of 0 disables it
+type RateLimit struct {
+	RequestsPerSecond float64 `json:"requestsPerSecond" yaml:"requestsPerSecond"`
+	Burst             int     `json:"burst" yaml:"burst"`
+}
+
+var SupportedStores = []string{
+	"beatport",
+	"beatsource",
+}
+
+const (
+	maxRetryAttempts = 100
+	// maxRetryDelaySeconds is the longest configurable retry delay, a day
+	maxRetryDelaySeconds = 24 * 60 * 60
+)
+
+var coverSizeRegex = regexp.MustCompile(`^[1-9][0-9]*x[1-9][0-9]*$`)
+
+// SupportedQualities lists the download qualities from best to worst
+var SupportedQualities = []string{
+	"lossless",
+	"high",
+	"medium",
+}
+
+var SupportedKeySystems = []string{
+	"standard",
+	"standard-short",
+	"openkey",
+	"camelot",
 }
 
 // DefaultConfig returns a new AppConfig with default values
 func DefaultConfig() *AppConfig {
 	return &AppConfig{
-		MaxGlobalWorkers:   5,
-		MaxDownloadWorkers: 3,
+		Credentials: Credentials{
+			TokenCachePath: "./beatportdl-credentials.json",
+		},
+		Downloads: Downloads{
+			Directory:        "./downloads",
+			Workers:          3,
+			Quality:          "lossless",
+			QualityFallback:  true,
+			SortByContext:    true,
+			FileExistsPolicy: library.ExistsSkip,
+			Retry: Retry{
+				MaxAttempts:      3,
+				BaseDelaySeconds: 10,
+				MaxDelaySeconds:  600,
+				Jitter:           0.2,
+			},
+		},
+		Naming: Naming{
+			TrackTemplate:       "{number}. {artists} - {name} ({mix_name})",
+			ReleaseTemplate:     "[{catalog_number}] {artists} - {name}",
+			PlaylistTemplate:    "{name} [{created_date}]",
+			ChartTemplate:       "{name} [{published_date}]",
+			LabelTemplate:       "{name} [{updated_date}]",
+			ArtistTemplate:      "{name}",
+			WhitespaceCharacter: "",
+			ArtistsLimit:        3,
+			ArtistsShortForm:    "VA",
+			TrackNumberPadding:  0,
+			KeySystem:           "standard-short",
+		},
+		Tagging: Tagging{
+			Enabled:  true,
+			Mappings: copyTagMappings(DefaultTagMappings),
+		},
+		Cover: Cover{
+			Size:  "1400x1400",
+			Embed: true,
+			Keep:  false,
+		},
+		Server: Server{
+			Address:           ":8080",
+			JobStorePath:      "./jobs.jsonl",
+			JobRetentionHours: 72,
+		},
+		API: API{
+			RateLimits: map[string]RateLimit{
+				"beatport":   {RequestsPerSecond: 4, Burst: 8},
+				"beatsource": {RequestsPerSecond: 4, Burst: 8},
+			},
+			Cache: MetadataCache{
+				Directory: "./cache",
+				TTLHours:  24,
+			},
+		},
 	}
 }
 
-// Parse loads the configuration from the specified YAML file
-func Parse(path string) (*AppConfig, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			// Return default config if file doesn't exist
-			return DefaultConfig(), nil
+// Validate checks the configuration for invalid values. Every problem is
+// reported, as a ValidationErrors keyed by YAML path.
+func (c *AppConfig) Validate() error {
+	var errs ValidationErrors
+
+	if c.Credentials.TokenCachePath == "" {
+		errs.add("credentials.tokenCachePath", "must not be empty")
+	}
+
+	if c.Downloads.Directory == "" {
+		errs.add("downloads.directory", "must not be empty")
+	}
+	if c.Downloads.Workers <= 0 {
+		errs.add("downloads.workers", "must be greater than 0")
+	}
+	if !validator.PermittedValue(c.Downloads.Quality, SupportedQualities...) {
+		errs.add("downloads.quality", "must be one of %s", strings.Join(SupportedQualities, ", "))
+	}
+	if !validator.PermittedValue(c.Downloads.FileExistsPolicy, library.ExistsPolicies...) {
+		errs.add("downloads.fileExistsPolicy", "must be one of %s", strings.Join(library.ExistsPolicies, ", "))
+	}
+	if c.Downloads.BandwidthLimit < 0 {
+		errs.add("downloads.bandwidthLimit", "must not be negative")
+	}
+	if c.Downloads.Retry.MaxAttempts <= 0 || c.Downloads.Retry.MaxAttempts > maxRetryAttempts {
+		errs.add("downloads.retry.maxAttempts", "must be between 1 and %d", maxRetryAttempts)
+	}
+	if c.Downloads.Retry.BaseDelaySeconds < 0 {
+		errs.add("downloads.retry.baseDelaySeconds", "must not be negative")
+	}
+	if c.Downloads.Retry.MaxDelaySeconds < c.Downloads.Retry.BaseDelaySeconds || c.Downloads.Retry.MaxDelaySeconds > maxRetryDelaySeconds {
+		errs.add("downloads.retry.maxDelaySeconds", "must be between baseDelaySeconds and %d", maxRetryDelaySeconds)
+	}
+	if c.Downloads.Retry.Jitter < 0 || c.Downloads.Retry.Jitter > 1 {
+		errs.add("downloads.retry.jitter", "must be between 0 and 1")
+	}
+
+	if c.Naming.TrackTemplate == "" {
+		errs.add("naming.trackTemplate", "must not be empty")
+	}
+	templates := map[string]string{
+		"releaseTemplate":  c.Naming.ReleaseTemplate,
+		"playlistTemplate": c.Naming.PlaylistTemplate,
+		"chartTemplate":    c.Naming.ChartTemplate,
+		"labelTemplate":    c.Naming.LabelTemplate,
+		"artistTemplate":   c.Naming.ArtistTemplate,
+	}
+	for key, template := range templates {
+		if template == "" {
+			errs.add("naming."+key, "must not be empty")
 		}
-		return nil, err
+	}
+	if c.Naming.ArtistsLimit < 0 {
+		errs.add("naming.artistsLimit", "must not be negative")
+	}
+	if c.Naming.TrackNumberPadding < 0 {
+		errs.add("naming.trackNumberPadding", "must not be negative")
+	}
+	if !validator.PermittedValue(c.Naming.KeySystem, SupportedKeySystems...) {
+		errs.add("naming.keySystem", "must be one of %s", strings.Join(SupportedKeySystems, ", "))
 	}
 
-	var config AppConfig
-	if err := yaml.Unmarshal(data, &config); err != nil {
-		return nil, err
+	errs = append(errs, validateTagMappings("tagging.mappings", c.Tagging.Mappings)...)
+
+	if !coverSizeRegex.MatchString(c.Cover.Size) {
+		errs.add("cover.size", "must be WIDTHxHEIGHT, got '%s'", c.Cover.Size)
+	}
+
+	if c.Server.Address == "" {
+		errs.add("server.address", "must not be empty")
+	}
+	if c.Server.JobStorePath == "" {
+		errs.add("server.jobStorePath", "must not be empty")
+	}
+	if c.Server.JobRetentionHours < 0 {
+		errs.add("server.jobRetentionHours", "must not be negative")
 	}
 
-	return &config, nil
+	for store, limit := range c.API.RateLimits {
+		path := "api.rateLimits." + store
+		if !validator.PermittedValue(store, SupportedStores...) {
+			errs.add(path, "unknown store, must be one of %s", strings.Join(SupportedStores, ", "))
+			continue
+		}
+		if limit.RequestsPerSecond < 0 {
+			errs.add(path+".requestsPerSecond", "must not be negative")
+		}
+		if limit.Burst < 0 {
+			errs.add(path+".burst", "must not be negative")
+		}
+	}
+	if c.API.Cache.TTLHours < 0 {
+		errs.add("api.cache.ttlHours", "must not be negative")
+	}
+	if c.API.Cache.TTLHours > 0 && c.API.Cache.Directory == "" {
+		errs.add("api.cache.directory", "must not be empty")
+	}
+
+	if c.Proxy != "" {
+		proxyUrl, err := url.Parse(c.Proxy)
+		if err != nil || proxyUrl.Scheme == "" || proxyUrl.Host == "" {
+			errs.add("proxy", "invalid url '%s'", c.Proxy)
+		}
+	}
+
+	if len(errs) > 0 {
+		sort.Slice(errs, func(i, j int) bool { return errs[i].Path < errs[j].Path })
+		return errs
+	}
+	return nil
 }
 
-// LoadConfig loads the configuration from the specified JSON file
-func LoadConfig(path string) (*AppConfig, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			// Return default config if file doesn't exist
-			return DefaultConfig(), nil
+// QualityChain returns the qualities to try for a download in the given
+// quality, best first. Without fallback only the quality itself is tried.
+func (c *AppConfig) QualityChain(quality string) []string {
+	if !c.Downloads.QualityFallback {
+		return []string{quality}
+	}
+	for i, q := range SupportedQualities {
+		if q == quality {
+			return SupportedQualities[i:]
 		}
+	}
+	return []string{quality}
+}
+
+// RetryDelay returns how long to wait before the given retry attempt,
+// doubling the base delay for every failed attempt and spreading it by the
+// configured jitter. The delay never exceeds the maximum delay.
+func (c *AppConfig) RetryDelay(attempt int) time.Duration {
+	retry := c.Downloads.Retry
+	limit := time.Duration(min(max(retry.MaxDelaySeconds, 0), maxRetryDelaySeconds)) * time.Second
+	delay := min(time.Duration(max(retry.BaseDelaySeconds, 0))*time.Second, limit)
+	// Doubling stops at the limit, so the delay can't overflow
+	for i := 1; i < attempt && delay < limit; i++ {
+		delay *= 2
+	}
+	if retry.Jitter > 0 {
+		delay = time.Duration(float64(delay) * (1 + retry.Jitter*(2*rand.Float64()-1)))
+	}
+	return min(delay, limit)
+}
+
+// NamingPreferences returns the naming options for the given template
+func (c *AppConfig) NamingPreferences(template string) beatport.NamingPreferences {
+	return beatport.NamingPreferences{
+		Template:           template,
+		Whitespace:         c.Naming.WhitespaceCharacter,
+		ArtistsLimit:       c.Naming.ArtistsLimit,
+		ArtistsShortForm:   c.Naming.ArtistsShortForm,
+		TrackNumberPadding: c.Naming.TrackNumberPadding,
+		KeySystem:          c.Naming.KeySystem,
+	}
+}
+
+// TokenCacheFile returns the file the token of a store is cached in.
+// Beatport keeps the configured path, other stores add their name to it.
+func (c Credentials) TokenCacheFile(store string) string {
+	if store == string(beatport.StoreBeatport) {
+		return c.TokenCachePath
+	}
+	ext := filepath.Ext(c.TokenCachePath)
+	return strings.TrimSuffix(c.TokenCachePath, ext) + "-" + store + ext
+}
+
+// DirectoryNaming returns the naming options for the directory of a
+// collection
+func (c *AppConfig) DirectoryNaming(linkType beatport.LinkType) beatport.NamingPreferences {
+	templates := map[beatport.LinkType]string{
+		beatport.ReleaseLink:  c.Naming.ReleaseTemplate,
+		beatport.PlaylistLink: c.Naming.PlaylistTemplate,
+		beatport.ChartLink:    c.Naming.ChartTemplate,
+		beatport.LabelLink:    c.Naming.LabelTemplate,
+		beatport.ArtistLink:   c.Naming.ArtistTemplate,
+	}
+	return c.NamingPreferences(templates[linkType])
+}
+
+// Parse loads the configuration from the specified YAML file
+func Parse(path string) (*AppConfig, error) {
+	// Start from the defaults so options missing from the file keep a sane
+	// value
+	config := DefaultConfig()
+	if _, _, err := config.readFile(path); err != nil {
 		return nil, err
 	}
 
-	var config AppConfig
-	if err := json.Unmarshal(data, &config); err != nil {
+	if err := config.Validate(); err != nil {
 		return nil, err
 	}
 
-	return &config, nil
+	return config, nil
 }
 
-// SaveConfig saves the configuration to the specified file
-func (c *AppConfig) SaveConfig(path string) error {
-	// Ensure directory exists
-	dir := filepath.Dir(path)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return err
+// readFile applies the options set in a YAML file and returns the paths of
+// the keys it contains. A missing file leaves the config as it is.
+func (c *AppConfig) readFile(path string) (map[string]bool, *migration, error) {
+	data, err := readConfigFile(path)
+	if err != nil {
+		return nil, nil, err
 	}
+	return c.readDocument(data)
+}
 
-	data, err := json.MarshalIndent(c, "", "  ")
+// readConfigFile returns the config file at path, or nil if there is none
+func readConfigFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// readDocument reads the contents of a config file into c. A nil document
+// stands for a missing file.
+func (c *AppConfig) readDocument(data []byte) (map[string]bool, *migration, error) {
+	if data == nil {
+		return nil, nil, nil
+	}
+
+	// Files written before options were grouped into sections are read
+	// as if they had been written with the current keys
+	m, err := migrateDocument(data)
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+	if m != nil {
+		data = m.document
+	}
+
+	// Keys the config doesn't know about are most likely typos, which would
+	// otherwise silently fall back to the default. The decoder's own strict
+	// mode can't be used as it rejects keys of the default maps.
+	present, err := checkKeys(data)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return os.WriteFile(path, data, 0644)
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return nil, nil, err
+	}
+	return present, m, nil
 }
 
-// Save saves the configuration to the specified YAML file
+// Save writes the configuration to the specified YAML file. The file is
+// replaced atomically, so a reader never sees half of it, and the previous
+// version is kept next to it with a .bak suffix.
 func (c *AppConfig) Save(path string) error {
-	// Ensure directory exists
-	dir := filepath.Dir(path)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	data, err := yaml.Marshal(c)
+	if err != nil {
 		return err
 	}
+	return SaveDocument(path, data)
+}
 
-	data, err := yaml.Marshal(c)
+// SaveDocument replaces the config file at path with data the same way as
+// Save. The data is written as is, without checking it.
+func SaveDocument(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	previous, err := os.ReadFile(path)
+	if err == nil {
+		if err := writeFileAtomic(path+".bak", previous); err != nil {
+			return fmt.Errorf("backup: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	return writeFileAtomic(path, data)
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it into place. The file may hold credentials, so only the owner can read
+// it.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(tmp.Name())
 
-	return os.WriteFile(path, data, 0644)
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
 }
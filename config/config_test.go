@@ -0,0 +1,97 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Downloads.Retry = Retry{MaxAttempts: maxRetryAttempts, BaseDelaySeconds: 10, MaxDelaySeconds: 300}
+
+	// Without jitter the delay doubles until it reaches the maximum
+	for attempt, want := range map[int]time.Duration{
+		0:   10 * time.Second,
+		1:   10 * time.Second,
+		2:   20 * time.Second,
+		3:   40 * time.Second,
+		5:   160 * time.Second,
+		6:   300 * time.Second,
+		40:  300 * time.Second,
+		100: 300 * time.Second,
+	} {
+		if got := cfg.RetryDelay(attempt); got != want {
+			t.Errorf("RetryDelay(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+
+	// Jitter spreads the delay around its base, never past the maximum
+	cfg.Downloads.Retry.Jitter = 0.5
+	for i := 0; i < 1000; i++ {
+		if got := cfg.RetryDelay(2); got < 10*time.Second || got > 30*time.Second {
+			t.Fatalf("RetryDelay(2) = %v, want between 10s and 30s", got)
+		}
+		if got := cfg.RetryDelay(64); got < 150*time.Second || got > 300*time.Second {
+			t.Fatalf("RetryDelay(64) = %v, want between 150s and 300s", got)
+		}
+	}
+
+	// A base delay above the maximum is capped from the first attempt on
+	cfg.Downloads.Retry = Retry{BaseDelaySeconds: 600, MaxDelaySeconds: 60}
+	if got := cfg.RetryDelay(1); got != time.Minute {
+		t.Errorf("RetryDelay(1) = %v, want %v", got, time.Minute)
+	}
+
+	// An unvalidated configuration still can't overflow
+	cfg.Downloads.Retry = Retry{BaseDelaySeconds: 10, MaxDelaySeconds: 1 << 62}
+	if got := cfg.RetryDelay(1000); got != maxRetryDelaySeconds*time.Second {
+		t.Errorf("RetryDelay(1000) = %v, want %v", got, maxRetryDelaySeconds*time.Second)
+	}
+}
+
+func TestValidateRetry(t *testing.T) {
+	tests := []struct {
+		name  string
+		retry Retry
+		paths []string
+	}{
+		{name: "defaults", retry: DefaultConfig().Downloads.Retry},
+		{
+			name:  "too many attempts",
+			retry: Retry{MaxAttempts: maxRetryAttempts + 1, BaseDelaySeconds: 10, MaxDelaySeconds: 60},
+			paths: []string{"downloads.retry.maxAttempts"},
+		},
+		{
+			name:  "maximum below the base delay",
+			retry: Retry{MaxAttempts: 3, BaseDelaySeconds: 10, MaxDelaySeconds: 5},
+			paths: []string{"downloads.retry.maxDelaySeconds"},
+		},
+		{
+			name:  "maximum too long",
+			retry: Retry{MaxAttempts: 3, BaseDelaySeconds: 10, MaxDelaySeconds: maxRetryDelaySeconds + 1},
+			paths: []string{"downloads.retry.maxDelaySeconds"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Downloads.Retry = tt.retry
+			err := cfg.Validate()
+			var paths []string
+			var errs ValidationErrors
+			if errors.As(err, &errs) {
+				for _, e := range errs {
+					paths = append(paths, e.Path)
+				}
+			} else if err != nil {
+				t.Fatalf("err = %v, want ValidationErrors", err)
+			}
+			if strings.Join(paths, ",") != strings.Join(tt.paths, ",") {
+				t.Errorf("errors for %v, want %v", paths, tt.paths)
+			}
+		})
+	}
+}
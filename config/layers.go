@@ -0,0 +1,402 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Source is the layer an option got its value from
+type Source string
+
+const (
+	SourceDefault Source = "default"
+	SourceFile    Source = "file"
+	SourceEnv     Source = "env"
+	SourceFlag    Source = "flag"
+)
+
+const (
+	// DefaultPath is the config file read when neither --config nor
+	// $BEATPORTDL_CONFIG name one
+	DefaultPath = "./config.yml"
+
+	// EnvPrefix starts every environment variable read by Load. The rest of
+	// the name is the option path in upper snake case, for example
+	// BEATPORTDL_DOWNLOADS_RETRY_MAX_ATTEMPTS.
+	EnvPrefix = "BEATPORTDL_"
+	// EnvConfigPath names the config file when --config isn't given
+	EnvConfigPath = EnvPrefix + "CONFIG"
+)
+
+// Loaded is a configuration put together from all layers
+type Loaded struct {
+	Config *AppConfig
+	// Path is the config file that was read, whether it exists or not
+	Path string
+	// Sources maps the path of every option to the layer that set it
+	Sources map[string]Source
+	// Args are the arguments left after the flags, only kept by LoadArgs
+	Args []string
+	// Warnings describe the legacy keys migrated while loading the file
+	Warnings []string
+
+	name    string
+	args    []string
+	environ []string
+}
+
+// Option is an effective option value along with where it came from
+type Option struct {
+	Path   string      `json:"path"`
+	Value  interface{} `json:"value"`
+	Source Source      `json:"source"`
+	Env    string      `json:"env,omitempty"`
+}
+
+// option is a leaf of AppConfig that can be overridden
+type option struct {
+	path   string
+	index  []int
+	kind   reflect.Kind
+	secret bool
+}
+
+// options lists every leaf of AppConfig. Maps are leaves as well, but can
+// only be set from the config file.
+func options() []option {
+	var opts []option
+	var walk func(t reflect.Type, path string, index []int)
+	walk = func(t reflect.Type, path string, index []int) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := yamlName(field)
+			if name == "-" {
+				continue
+			}
+			fieldPath := joinPath(path, name)
+			fieldIndex := append(append([]int{}, index...), i)
+			if field.Type.Kind() == reflect.Struct {
+				walk(field.Type, fieldPath, fieldIndex)
+				continue
+			}
+			opts = append(opts, option{
+				path:  fieldPath,
+				index: fieldIndex,
+				kind:  field.Type.Kind(),
+				// Options hidden from the JSON API are never echoed back
+				secret: field.Tag.Get("json") == "-",
+			})
+		}
+	}
+	walk(reflect.TypeOf(AppConfig{}), "", nil)
+	return opts
+}
+
+func (o option) settable() bool {
+	return o.kind != reflect.Map
+}
+
+// envName returns the environment variable overriding the option
+func (o option) envName() string {
+	var b strings.Builder
+	b.WriteString(EnvPrefix)
+	for i, segment := range strings.Split(o.path, ".") {
+		if i > 0 {
+			b.WriteByte('_')
+		}
+		runes := []rune(segment)
+		for j, r := range runes {
+			// A new word starts at an upper case letter following a lower
+			// case one, or ending a run of upper case letters
+			if j > 0 && unicode.IsUpper(r) && (unicode.IsLower(runes[j-1]) ||
+				(j+1 < len(runes) && unicode.IsLower(runes[j+1]))) {
+				b.WriteByte('_')
+			}
+			b.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return b.String()
+}
+
+func (o option) value(c *AppConfig) reflect.Value {
+	return reflect.ValueOf(c).Elem().FieldByIndex(o.index)
+}
+
+func (o option) set(c *AppConfig, s string) error {
+	v := o.value(c)
+	switch o.kind {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a boolean", s)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("'%s' is not an integer", s)
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a number", s)
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("can only be set in the config file")
+	}
+	return nil
+}
+
+// optionFlag records the raw value of an option flag. It is applied once
+// the lower layers have been loaded.
+type optionFlag struct {
+	value  string
+	isBool bool
+}
+
+func (f *optionFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.value
+}
+
+func (f *optionFlag) Set(s string) error {
+	f.value = s
+	return nil
+}
+
+func (f *optionFlag) IsBoolFlag() bool {
+	return f.isBool
+}
+
+// Load puts the configuration together from its layers, each overriding
+// the one before: the defaults, the config file named by --config or
+// $BEATPORTDL_CONFIG, BEATPORTDL_* environment variables and finally the
+// command line flags. Every option has a flag named after its path, such
+// as --downloads.workers. environ is in the form returned by os.Environ.
+func Load(name string, args, environ []string) (*Loaded, error) {
+	l, err := LoadArgs(name, args, environ)
+	if err != nil {
+		return nil, err
+	}
+	if len(l.Args) > 0 {
+		return nil, fmt.Errorf("unexpected argument '%s'", l.Args[0])
+	}
+	return l, nil
+}
+
+// LoadArgs is Load for commands taking arguments after the flags, which
+// are returned in Args.
+func LoadArgs(name string, args, environ []string) (*Loaded, error) {
+	l, migrated, err := load(name, args, environ, readConfigFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// The file is rewritten with the current keys once it is known to be
+	// valid, the original stays in the backup
+	if migrated != nil {
+		l.Warnings = migrated.warnings
+		if err := SaveDocument(l.Path, migrated.document); err != nil {
+			l.Warnings = append(l.Warnings, fmt.Sprintf("could not rewrite %s with the current keys: %v", l.Path, err))
+		}
+	}
+	return l, nil
+}
+
+// load puts the configuration together with the config file returned by
+// read, which returns nil when there is none. The file is never written.
+func load(name string, args, environ []string, read func(path string) ([]byte, error)) (*Loaded, *migration, error) {
+	opts := options()
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	configPath := fs.String("config", "", fmt.Sprintf("path of the config file (default $%s or %s)", EnvConfigPath, DefaultPath))
+	flags := make(map[string]*optionFlag, len(opts))
+	for _, o := range opts {
+		if !o.settable() {
+			continue
+		}
+		f := &optionFlag{isBool: o.kind == reflect.Bool}
+		flags[o.path] = f
+		fs.Var(f, o.path, fmt.Sprintf("set %s, also $%s", o.path, o.envName()))
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	env := make(map[string]string)
+	for _, kv := range environ {
+		if key, value, ok := strings.Cut(kv, "="); ok && strings.HasPrefix(key, EnvPrefix) {
+			env[key] = value
+		}
+	}
+
+	l := &Loaded{
+		Config:  DefaultConfig(),
+		Path:    *configPath,
+		Sources: make(map[string]Source, len(opts)),
+		Args:    fs.Args(),
+		name:    name,
+		args:    args,
+		environ: environ,
+	}
+	if l.Path == "" {
+		l.Path = env[EnvConfigPath]
+	}
+	if l.Path == "" {
+		l.Path = DefaultPath
+	}
+	for _, o := range opts {
+		l.Sources[o.path] = SourceDefault
+	}
+
+	document, err := read(l.Path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", l.Path, err)
+	}
+	present, migrated, err := l.Config.readDocument(document)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", l.Path, err)
+	}
+	for _, o := range opts {
+		if present[o.path] {
+			l.Sources[o.path] = SourceFile
+		}
+	}
+
+	var errs ValidationErrors
+	for _, o := range opts {
+		value, ok := env[o.envName()]
+		if !ok || !o.settable() {
+			continue
+		}
+		if err := o.set(l.Config, value); err != nil {
+			errs.add(o.path, "$%s: %v", o.envName(), err)
+			continue
+		}
+		l.Sources[o.path] = SourceEnv
+	}
+	for _, o := range opts {
+		f, ok := flags[o.path]
+		if !ok || !set[o.path] {
+			continue
+		}
+		if err := o.set(l.Config, f.value); err != nil {
+			errs.add(o.path, "--%s: %v", o.path, err)
+			continue
+		}
+		l.Sources[o.path] = SourceFlag
+	}
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+
+	if err := l.Config.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return l, migrated, nil
+}
+
+// Reload loads the configuration again with the same flags and
+// environment, picking up changes to the config file.
+func (l *Loaded) Reload() (*Loaded, error) {
+	return LoadArgs(l.name, l.args, l.environ)
+}
+
+// Preview returns the configuration Reload would load if the config file
+// held document, without writing the file. It goes through the same
+// checks, so a document accepted here can be saved and reloaded.
+func (l *Loaded) Preview(document []byte) (*Loaded, error) {
+	next, _, err := load(l.name, l.args, l.environ, func(string) ([]byte, error) {
+		return document, nil
+	})
+	return next, err
+}
+
+// Overridden returns the options changed by a merge patch that the
+// environment or the command line override, so changing them in the
+// config file has no effect while the override is in place.
+func (l *Loaded) Overridden(patch map[string]interface{}) []string {
+	paths := patchPaths(patch, "")
+	var overridden []string
+	for _, o := range options() {
+		if source := l.Sources[o.path]; source != SourceEnv && source != SourceFlag {
+			continue
+		}
+		for _, path := range paths {
+			if path == o.path || strings.HasPrefix(o.path, path+".") || strings.HasPrefix(path, o.path+".") {
+				overridden = append(overridden, o.path)
+				break
+			}
+		}
+	}
+	sort.Strings(overridden)
+	return overridden
+}
+
+// patchPaths returns the paths a merge patch sets or removes. Legacy keys
+// are returned as the path that replaced them.
+func patchPaths(patch map[string]interface{}, prefix string) []string {
+	var paths []string
+	for key, value := range patch {
+		path := joinPath(prefix, key)
+		if replacement := legacyKeys[key]; prefix == "" && replacement != "" {
+			path = replacement
+		}
+		if section, ok := value.(map[string]interface{}); ok && len(section) > 0 {
+			paths = append(paths, patchPaths(section, path)...)
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// Diff returns the paths of the options that differ between a and b
+func Diff(a, b *AppConfig) []string {
+	var changed []string
+	for _, o := range options() {
+		if !reflect.DeepEqual(o.value(a).Interface(), o.value(b).Interface()) {
+			changed = append(changed, o.path)
+		}
+	}
+	return changed
+}
+
+// Options lists every option with its effective value and source, sorted
+// by path. Secrets only show whether they are set.
+func (l *Loaded) Options() []Option {
+	opts := options()
+	result := make([]Option, 0, len(opts))
+	for _, o := range opts {
+		value := o.value(l.Config).Interface()
+		if o.secret && !o.value(l.Config).IsZero() {
+			value = "********"
+		}
+		option := Option{
+			Path:   o.path,
+			Value:  value,
+			Source: l.Sources[o.path],
+		}
+		if o.settable() {
+			option.Env = o.envName()
+		}
+		result = append(result, option)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result
+}
@@ -0,0 +1,204 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfig(t, "downloads:\n  workers: 2\n  quality: high\nnaming:\n  artistsLimit: 4\n")
+
+	tests := []struct {
+		name       string
+		args       []string
+		environ    []string
+		workers    int
+		source     Source
+		quality    string
+		qualitySrc Source
+	}{
+		{
+			name:       "file",
+			workers:    2,
+			source:     SourceFile,
+			quality:    "high",
+			qualitySrc: SourceFile,
+		},
+		{
+			name:       "env over file",
+			environ:    []string{"BEATPORTDL_DOWNLOADS_WORKERS=5", "OTHER_DOWNLOADS_WORKERS=9"},
+			workers:    5,
+			source:     SourceEnv,
+			quality:    "high",
+			qualitySrc: SourceFile,
+		},
+		{
+			name:       "flag over env",
+			args:       []string{"--downloads.workers", "7", "--downloads.quality=medium"},
+			environ:    []string{"BEATPORTDL_DOWNLOADS_WORKERS=5"},
+			workers:    7,
+			source:     SourceFlag,
+			quality:    "medium",
+			qualitySrc: SourceFlag,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"--config", path}, tt.args...)
+			l, err := Load("test", args, tt.environ)
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if l.Config.Downloads.Workers != tt.workers || l.Sources["downloads.workers"] != tt.source {
+				t.Errorf("downloads.workers = %d from %s, want %d from %s", l.Config.Downloads.Workers, l.Sources["downloads.workers"], tt.workers, tt.source)
+			}
+			if l.Config.Downloads.Quality != tt.quality || l.Sources["downloads.quality"] != tt.qualitySrc {
+				t.Errorf("downloads.quality = %s from %s, want %s from %s", l.Config.Downloads.Quality, l.Sources["downloads.quality"], tt.quality, tt.qualitySrc)
+			}
+			if l.Config.Naming.ArtistsLimit != 4 || l.Sources["naming.artistsLimit"] != SourceFile {
+				t.Errorf("naming.artistsLimit = %d from %s", l.Config.Naming.ArtistsLimit, l.Sources["naming.artistsLimit"])
+			}
+			if l.Sources["cover.size"] != SourceDefault {
+				t.Errorf("cover.size source = %s, want default", l.Sources["cover.size"])
+			}
+		})
+	}
+}
+
+func TestLoadConfigPath(t *testing.T) {
+	path := writeConfig(t, "downloads:\n  workers: 6\n")
+	l, err := Load("test", nil, []string{EnvConfigPath + "=" + path})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if l.Path != path || l.Config.Downloads.Workers != 6 {
+		t.Errorf("loaded %s with %d workers, want %s with 6", l.Path, l.Config.Downloads.Workers, path)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	path := writeConfig(t, "downloads:\n  workers: 2\n")
+
+	tests := []struct {
+		name    string
+		args    []string
+		environ []string
+		path    string
+	}{
+		{name: "invalid env", environ: []string{"BEATPORTDL_DOWNLOADS_WORKERS=many"}, path: "downloads.workers"},
+		{name: "invalid flag", args: []string{"--cover.embed=maybe"}, path: "cover.embed"},
+		{name: "failed validation", args: []string{"--downloads.workers=0"}, path: "downloads.workers"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"--config", path}, tt.args...)
+			_, err := Load("test", args, tt.environ)
+			errs, ok := err.(ValidationErrors)
+			if !ok || len(errs) != 1 || errs[0].Path != tt.path {
+				t.Errorf("err = %v, want a single error for %s", err, tt.path)
+			}
+		})
+	}
+
+	if _, err := Load("test", []string{"--config", path, "extra"}, nil); err == nil {
+		t.Error("Load accepted an argument after the flags")
+	}
+	l, err := LoadArgs("test", []string{"--config", path, "extra"}, nil)
+	if err != nil || len(l.Args) != 1 || l.Args[0] != "extra" {
+		t.Errorf("LoadArgs = %v, %v; want the argument kept", l, err)
+	}
+}
+
+func TestOptionsHideSecrets(t *testing.T) {
+	path := writeConfig(t, "credentials:\n  username: user\n  password: secret\n")
+	l, err := Load("test", []string{"--config", path}, nil)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	for _, o := range l.Options() {
+		switch o.Path {
+		case "credentials.username", "credentials.password":
+			if o.Value != "********" {
+				t.Errorf("%s = %v, want it hidden", o.Path, o.Value)
+			}
+		case "downloads.workers":
+			if o.Env != "BEATPORTDL_DOWNLOADS_WORKERS" {
+				t.Errorf("%s env = %s", o.Path, o.Env)
+			}
+		}
+	}
+}
+
+func TestPreview(t *testing.T) {
+	path := writeConfig(t, "downloads:\n  workers: 2\n")
+	l, err := Load("test", []string{"--config", path}, []string{"BEATPORTDL_DOWNLOADS_RETRY_BASE_DELAY_SECONDS=300"})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	patch, err := DecodePatch([]byte(`{"downloads": {"retry": {"maxDelaySeconds": 100}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The patch is fine over the defaults, but not below the environment
+	document, _, err := ApplyPatch([]byte("downloads:\n  workers: 2\n"), patch)
+	if err != nil {
+		t.Fatalf("ApplyPatch: %v", err)
+	}
+	_, err = l.Preview(document)
+	var errs ValidationErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Path != "downloads.retry.maxDelaySeconds" {
+		t.Fatalf("Preview = %v, want an error for downloads.retry.maxDelaySeconds", err)
+	}
+
+	next, err := l.Preview([]byte("downloads:\n  workers: 4\n"))
+	if err != nil {
+		t.Fatalf("Preview: %v", err)
+	}
+	if next.Config.Downloads.Workers != 4 || next.Config.Downloads.Retry.BaseDelaySeconds != 300 {
+		t.Errorf("downloads = %+v", next.Config.Downloads)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "downloads:\n  workers: 2\n" {
+		t.Errorf("Preview wrote the config file: %q", data)
+	}
+}
+
+func TestOverridden(t *testing.T) {
+	path := writeConfig(t, "")
+	l, err := Load("test", []string{"--config", path, "--naming.artistsLimit", "2"}, []string{"BEATPORTDL_DOWNLOADS_WORKERS=5"})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	tests := []struct {
+		patch string
+		want  string
+	}{
+		{patch: `{"downloads": {"quality": "high"}}`, want: ""},
+		{patch: `{"downloads": {"workers": 3, "quality": "high"}}`, want: "downloads.workers"},
+		{patch: `{"maxDownloadWorkers": 3}`, want: "downloads.workers"},
+		{patch: `{"downloads": null, "naming": {"artistsLimit": null}}`, want: "downloads.workers,naming.artistsLimit"},
+	}
+	for _, tt := range tests {
+		patch, err := DecodePatch([]byte(tt.patch))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(l.Overridden(patch), ","); got != tt.want {
+			t.Errorf("Overridden(%s) = %s, want %s", tt.patch, got, tt.want)
+		}
+	}
+}
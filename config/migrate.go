@@ -0,0 +1,98 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// legacyKeys maps the top level keys of the flat config written by older
+// versions to the path of the option that replaced them. Keys mapped to an
+// empty path are no longer supported.
+var legacyKeys = map[string]string{
+	"maxGlobalWorkers":   "",
+	"maxDownloadWorkers": "downloads.workers",
+}
+
+// migration is the rewrite of a config file using legacy keys
+type migration struct {
+	document []byte
+	warnings []string
+}
+
+// migrateDocument moves the legacy keys of a config file to their current
+// path. It returns nil when the file has none.
+func migrateDocument(data []byte) (*migration, error) {
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	m, ok := normalize(doc).(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	warnings := migrateLegacy(m)
+	if len(warnings) == 0 {
+		return nil, nil
+	}
+	document, err := yaml.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return &migration{document: document, warnings: warnings}, nil
+}
+
+// migrateLegacy moves the legacy keys of a config document or patch to
+// their current path and describes every change made. An option already
+// set under its current path keeps that value.
+func migrateLegacy(doc map[string]interface{}) []string {
+	keys := make([]string, 0, len(doc))
+	for key := range doc {
+		if _, ok := legacyKeys[key]; ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var warnings []string
+	for _, key := range keys {
+		value := doc[key]
+		delete(doc, key)
+		path := legacyKeys[key]
+		switch {
+		case path == "":
+			warnings = append(warnings, fmt.Sprintf("%s is deprecated and no longer used, it was removed", key))
+		case !setPath(doc, path, value):
+			warnings = append(warnings, fmt.Sprintf("%s is deprecated, it was removed as %s is set", key, path))
+		default:
+			warnings = append(warnings, fmt.Sprintf("%s is deprecated, it was moved to %s", key, path))
+		}
+	}
+	return warnings
+}
+
+// setPath sets the value at a dotted path, creating the sections on the
+// way. It leaves an existing value alone and reports whether it was set.
+func setPath(doc map[string]interface{}, path string, value interface{}) bool {
+	segments := strings.Split(path, ".")
+	for _, segment := range segments[:len(segments)-1] {
+		existing, found := doc[segment]
+		section, ok := existing.(map[string]interface{})
+		if !ok {
+			if found && existing != nil {
+				return false
+			}
+			section = make(map[string]interface{})
+			doc[segment] = section
+		}
+		doc = section
+	}
+	last := segments[len(segments)-1]
+	if _, ok := doc[last]; ok {
+		return false
+	}
+	doc[last] = value
+	return true
+}
@@ -0,0 +1,110 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadLegacyConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		check    func(t *testing.T, c *AppConfig)
+		warnings int
+	}{
+		{
+			name: "baseline",
+			file: "maxGlobalWorkers: 5\nmaxDownloadWorkers: 4\n",
+			check: func(t *testing.T, c *AppConfig) {
+				if c.Downloads.Workers != 4 {
+					t.Errorf("downloads.workers = %d, want 4", c.Downloads.Workers)
+				}
+			},
+			warnings: 2,
+		},
+		{
+			name: "existing section",
+			file: "maxDownloadWorkers: 2\ndownloads:\n  directory: /music\nproxy: http://proxy:3128\n",
+			check: func(t *testing.T, c *AppConfig) {
+				if c.Downloads.Workers != 2 || c.Downloads.Directory != "/music" {
+					t.Errorf("downloads = %+v", c.Downloads)
+				}
+				if c.Proxy != "http://proxy:3128" {
+					t.Errorf("proxy = %q", c.Proxy)
+				}
+			},
+			warnings: 1,
+		},
+		{
+			name: "current key wins",
+			file: "maxDownloadWorkers: 2\ndownloads:\n  workers: 7\n",
+			check: func(t *testing.T, c *AppConfig) {
+				if c.Downloads.Workers != 7 {
+					t.Errorf("downloads.workers = %d, want 7", c.Downloads.Workers)
+				}
+			},
+			warnings: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yml")
+			if err := os.WriteFile(path, []byte(tt.file), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			l, err := Load("test", []string{"--config", path}, nil)
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			tt.check(t, l.Config)
+			if len(l.Warnings) != tt.warnings {
+				t.Errorf("warnings = %q, want %d", l.Warnings, tt.warnings)
+			}
+			if l.Sources["downloads.workers"] != SourceFile {
+				t.Errorf("downloads.workers source = %s, want file", l.Sources["downloads.workers"])
+			}
+
+			backup, err := os.ReadFile(path + ".bak")
+			if err != nil || string(backup) != tt.file {
+				t.Errorf("backup = %q, %v", backup, err)
+			}
+			rewritten, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(rewritten), "maxDownloadWorkers") {
+				t.Errorf("rewritten file still has legacy keys:\n%s", rewritten)
+			}
+
+			// The rewritten file loads the same without any migration
+			again, err := l.Reload()
+			if err != nil {
+				t.Fatalf("Reload: %v", err)
+			}
+			if len(again.Warnings) != 0 {
+				t.Errorf("warnings after rewrite = %q", again.Warnings)
+			}
+			if diff := Diff(l.Config, again.Config); len(diff) != 0 {
+				t.Errorf("rewritten file changed %v", diff)
+			}
+		})
+	}
+}
+
+func TestApplyPatchLegacyKeys(t *testing.T) {
+	patch, err := DecodePatch([]byte(`{"maxGlobalWorkers": 5, "maxDownloadWorkers": 6}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, c, err := ApplyPatch([]byte("maxDownloadWorkers: 2\n"), patch)
+	if err != nil {
+		t.Fatalf("ApplyPatch: %v", err)
+	}
+	if c.Downloads.Workers != 6 {
+		t.Errorf("downloads.workers = %d, want 6", c.Downloads.Workers)
+	}
+}
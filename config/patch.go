@@ -0,0 +1,131 @@
+package config
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+// DecodePatch decodes a JSON Merge Patch. Since JSON is a subset of YAML,
+// the patch may be written in either.
+func DecodePatch(data []byte) (map[string]interface{}, error) {
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	patch, ok := normalize(doc).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("patch must be an object")
+	}
+	return patch, nil
+}
+
+// ApplyPatch applies a JSON Merge Patch (RFC 7386) to a config file and
+// returns the new file along with the resulting configuration, which goes
+// through the same checks as the file itself. A key set to null is removed
+// from the file, which resets the option to its default.
+func ApplyPatch(document []byte, patch map[string]interface{}) ([]byte, *AppConfig, error) {
+	var doc interface{}
+	if err := yaml.Unmarshal(document, &doc); err != nil {
+		return nil, nil, err
+	}
+	root, ok := normalize(doc).(map[string]interface{})
+	if !ok {
+		root = make(map[string]interface{})
+	}
+	// Legacy keys are accepted in the file and the patch alike
+	migrateLegacy(root)
+	migrateLegacy(patch)
+	seedTagMappings(root, patch)
+	merged := mergePatch(root, patch)
+
+	data, err := yaml.Marshal(merged)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := checkKeys(data); err != nil {
+		return nil, nil, err
+	}
+	config := DefaultConfig()
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return data, config, nil
+}
+
+// seedTagMappings copies the default mapping of every format a patch
+// changes into the document, unless it has one of its own. A format's
+// mapping replaces the default one as a whole when the config is read, so
+// a patch setting some of its fields would otherwise drop the others.
+func seedTagMappings(root map[string]interface{}, patch map[string]interface{}) {
+	tagging, _ := patch["tagging"].(map[string]interface{})
+	formats, _ := tagging["mappings"].(map[string]interface{})
+	for format, fields := range formats {
+		defaults, ok := DefaultTagMappings[format]
+		if _, isMap := fields.(map[string]interface{}); !ok || !isMap {
+			continue
+		}
+		mappings := childMap(childMap(root, "tagging"), "mappings")
+		if _, ok := mappings[format]; ok {
+			continue
+		}
+		seeded := make(map[string]interface{}, len(defaults))
+		for field, tag := range defaults {
+			seeded[field] = tag
+		}
+		mappings[format] = seeded
+	}
+}
+
+// childMap returns the map under key, adding an empty one when there is
+// none.
+func childMap(m map[string]interface{}, key string) map[string]interface{} {
+	if child, ok := m[key].(map[string]interface{}); ok {
+		return child
+	}
+	child := make(map[string]interface{})
+	m[key] = child
+	return child
+}
+
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchMap, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetMap, ok := target.(map[string]interface{})
+	if !ok {
+		targetMap = make(map[string]interface{})
+	}
+	for key, value := range patchMap {
+		if value == nil {
+			delete(targetMap, key)
+			continue
+		}
+		targetMap[key] = mergePatch(targetMap[key], value)
+	}
+	return targetMap
+}
+
+// normalize turns the maps decoded from YAML into maps keyed by string, as
+// decoded from JSON.
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = normalize(value)
+		}
+		return m
+	case []interface{}:
+		for i := range v {
+			v[i] = normalize(v[i])
+		}
+		return v
+	default:
+		return v
+	}
+}
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestApplyPatch(t *testing.T) {
+	document := "downloads:\n  workers: 2\n  quality: high\nnaming:\n  artistsLimit: 4\n"
+
+	tests := []struct {
+		name  string
+		patch string
+		check func(t *testing.T, c *AppConfig)
+		// file lists what the saved document must and must not contain
+		file    []string
+		notFile []string
+	}{
+		{
+			name:  "merge keeps siblings",
+			patch: `{"downloads": {"workers": 5}}`,
+			check: func(t *testing.T, c *AppConfig) {
+				if c.Downloads.Workers != 5 || c.Downloads.Quality != "high" {
+					t.Errorf("downloads = %+v", c.Downloads)
+				}
+			},
+			file:    []string{"workers: 5", "quality: high", "artistsLimit: 4"},
+			notFile: []string{"coverSize", "size:"},
+		},
+		{
+			name:  "null resets to the default",
+			patch: `{"downloads": {"quality": null}, "naming": null}`,
+			check: func(t *testing.T, c *AppConfig) {
+				defaults := DefaultConfig()
+				if c.Downloads.Quality != defaults.Downloads.Quality || c.Naming.ArtistsLimit != defaults.Naming.ArtistsLimit {
+					t.Errorf("quality = %s, artistsLimit = %d", c.Downloads.Quality, c.Naming.ArtistsLimit)
+				}
+				if c.Downloads.Workers != 2 {
+					t.Errorf("downloads.workers = %d, want 2", c.Downloads.Workers)
+				}
+			},
+			file:    []string{"workers: 2"},
+			notFile: []string{"quality", "naming"},
+		},
+		{
+			name:  "yaml patch",
+			patch: "cover:\n  embed: false\n",
+			check: func(t *testing.T, c *AppConfig) {
+				if c.Cover.Embed {
+					t.Error("cover.embed is still true")
+				}
+			},
+			file: []string{"embed: false"},
+		},
+		{
+			name:  "partial tag mapping keeps the other fields",
+			patch: `{"tagging": {"mappings": {"flac": {"track_bpm": "TEMPO", "track_isrc": null}}}}`,
+			check: func(t *testing.T, c *AppConfig) {
+				flac := c.Tagging.Mappings["flac"]
+				if flac["track_bpm"] != "TEMPO" || flac["track_name"] != "TITLE" || flac["track_isrc"] != "" {
+					t.Errorf("flac mappings = %v", flac)
+				}
+				if len(c.Tagging.Mappings["m4a"]) != len(DefaultTagMappings["m4a"]) {
+					t.Errorf("m4a mappings = %v, want the defaults", c.Tagging.Mappings["m4a"])
+				}
+			},
+			file:    []string{"track_bpm: TEMPO", "track_name: TITLE", "workers: 2"},
+			notFile: []string{"ISRC", "m4a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch, err := DecodePatch([]byte(tt.patch))
+			if err != nil {
+				t.Fatalf("DecodePatch: %v", err)
+			}
+			saved, c, err := ApplyPatch([]byte(document), patch)
+			if err != nil {
+				t.Fatalf("ApplyPatch: %v", err)
+			}
+			tt.check(t, c)
+			for _, s := range tt.file {
+				if !strings.Contains(string(saved), s) {
+					t.Errorf("saved document lacks %q:\n%s", s, saved)
+				}
+			}
+			for _, s := range tt.notFile {
+				if strings.Contains(string(saved), s) {
+					t.Errorf("saved document has %q:\n%s", s, saved)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyPatchRejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		paths []string
+	}{
+		{name: "unknown key", patch: `{"downloads": {"wrokers": 2}}`, paths: []string{"downloads.wrokers"}},
+		{name: "wrong type", patch: `{"naming": {"artistsLimit": "x"}}`, paths: []string{"naming.artistsLimit"}},
+		{
+			name:  "every invalid value",
+			patch: `{"downloads": {"workers": 0, "quality": "best"}, "cover": {"size": "big"}}`,
+			paths: []string{"cover.size", "downloads.quality", "downloads.workers"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch, err := DecodePatch([]byte(tt.patch))
+			if err != nil {
+				t.Fatalf("DecodePatch: %v", err)
+			}
+			_, _, err = ApplyPatch(nil, patch)
+			var errs ValidationErrors
+			if !errors.As(err, &errs) {
+				t.Fatalf("err = %v, want ValidationErrors", err)
+			}
+			var paths []string
+			for _, e := range errs {
+				paths = append(paths, e.Path)
+			}
+			if strings.Join(paths, ",") != strings.Join(tt.paths, ",") {
+				t.Errorf("errors for %v, want %v", paths, tt.paths)
+			}
+		})
+	}
+}
+
+func TestDecodePatchRequiresObject(t *testing.T) {
+	for _, data := range []string{`[1, 2]`, `"workers"`, `3`} {
+		if _, err := DecodePatch([]byte(data)); err == nil {
+			t.Errorf("DecodePatch(%s) accepted a non-object", data)
+		}
+	}
+}
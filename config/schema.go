@@ -0,0 +1,163 @@
+package config
+
+import (
+	"reflect"
+
+	"github.com/unspok3n/beatportdl-ui/internal/library"
+)
+
+// optionDescriptions documents the options in the JSON Schema. Keys of maps
+// are written as "*".
+var optionDescriptions = map[string]string{
+	"credentials":                "Beatport account used to log in",
+	"credentials.username":       "Beatport username, never returned by the API",
+	"credentials.password":       "Beatport password, never returned by the API",
+	"credentials.tokenCachePath": "File the Beatport token is cached in, other stores add their name to it",
+
+	"downloads":                        "Where and how tracks are downloaded",
+	"downloads.directory":              "Directory finished tracks are moved into",
+	"downloads.workers":                "Number of tracks downloaded at the same time",
+	"downloads.quality":                "Quality downloaded when a request doesn't ask for one",
+	"downloads.qualityFallback":        "Download the next lower quality when the requested one isn't available",
+	"downloads.sortByContext":          "Put tracks into a directory named after their release",
+	"downloads.fileExistsPolicy":       "What to do when the file already exists",
+	"downloads.bandwidthLimit":         "Combined download speed limit in bytes per second, 0 for none",
+	"downloads.retry":                  "Retries of failed downloads",
+	"downloads.retry.maxAttempts":      "Number of attempts before a download fails",
+	"downloads.retry.baseDelaySeconds": "Delay before the first retry, doubled for every further one",
+	"downloads.retry.maxDelaySeconds":  "Longest delay between retries",
+	"downloads.retry.jitter":           "Random spread of the retry delay, as a fraction of it",
+
+	"naming":                     "Templates for file and directory names",
+	"naming.trackTemplate":       "File name of a track",
+	"naming.releaseTemplate":     "Directory name of a release",
+	"naming.playlistTemplate":    "Directory name of a playlist",
+	"naming.chartTemplate":       "Directory name of a chart",
+	"naming.labelTemplate":       "Directory name of a label",
+	"naming.artistTemplate":      "Directory name of an artist",
+	"naming.whitespaceCharacter": "Character replacing spaces in names, empty to keep them",
+	"naming.artistsLimit":        "Number of artists listed before the short form is used",
+	"naming.artistsShortForm":    "Replaces the artists when there are more than the limit",
+	"naming.trackNumberPadding":  "Width of track numbers, 0 for the width of the track count",
+	"naming.keySystem":           "Notation of musical keys",
+
+	"tagging":            "Tags written to downloaded files",
+	"tagging.enabled":    "Write tags to downloaded files",
+	"tagging.mappings":   "Tag written for each field, per file format",
+	"tagging.mappings.*": "Tag written for each field of this format",
+
+	"cover":       "Release artwork",
+	"cover.size":  "Size of the artwork as WIDTHxHEIGHT",
+	"cover.embed": "Embed the artwork into tagged files",
+	"cover.keep":  "Save the artwork as cover.jpg in release directories",
+
+	"server":                   "HTTP server",
+	"server.address":           "Address the server listens on",
+	"server.jobStorePath":      "File the job list is kept in",
+	"server.jobRetentionHours": "Hours finished jobs are kept, 0 to keep them forever",
+
+	"api":                                "Beatport and Beatsource API",
+	"api.rateLimits":                     "Request rate limit per store",
+	"api.rateLimits.*.requestsPerSecond": "Requests per second, 0 for no limit",
+	"api.rateLimits.*.burst":             "Requests allowed at once before the limit applies",
+	"api.cache":                          "Cache of catalog lookups",
+	"api.cache.directory":                "Directory the cache is kept in",
+	"api.cache.ttlHours":                 "Hours lookups are cached for, 0 disables the cache",
+
+	"proxy": "URL of the proxy used for all requests, empty for none",
+}
+
+// optionConstraints adds validation keywords to the JSON Schema, mirroring
+// the checks of Validate
+var optionConstraints = map[string]map[string]interface{}{
+	"credentials.username":               {"writeOnly": true},
+	"credentials.password":               {"writeOnly": true, "format": "password"},
+	"downloads.workers":                  {"minimum": 1},
+	"downloads.quality":                  {"enum": SupportedQualities},
+	"downloads.fileExistsPolicy":         {"enum": library.ExistsPolicies},
+	"downloads.bandwidthLimit":           {"minimum": 0},
+	"downloads.retry.maxAttempts":        {"minimum": 1, "maximum": maxRetryAttempts},
+	"downloads.retry.baseDelaySeconds":   {"minimum": 0, "maximum": maxRetryDelaySeconds},
+	"downloads.retry.maxDelaySeconds":    {"minimum": 0, "maximum": maxRetryDelaySeconds},
+	"downloads.retry.jitter":             {"minimum": 0, "maximum": 1},
+	"naming.trackTemplate":               {"minLength": 1},
+	"naming.releaseTemplate":             {"minLength": 1},
+	"naming.playlistTemplate":            {"minLength": 1},
+	"naming.chartTemplate":               {"minLength": 1},
+	"naming.labelTemplate":               {"minLength": 1},
+	"naming.artistTemplate":              {"minLength": 1},
+	"naming.artistsLimit":                {"minimum": 0},
+	"naming.trackNumberPadding":          {"minimum": 0},
+	"naming.keySystem":                   {"enum": SupportedKeySystems},
+	"tagging.mappings":                   {"propertyNames": map[string]interface{}{"enum": SupportedTagMappingFormats}},
+	"tagging.mappings.*":                 {"propertyNames": map[string]interface{}{"enum": SupportedTagMappingFields}},
+	"cover.size":                         {"pattern": coverSizeRegex.String()},
+	"server.address":                     {"minLength": 1},
+	"server.jobStorePath":                {"minLength": 1},
+	"server.jobRetentionHours":           {"minimum": 0},
+	"api.rateLimits":                     {"propertyNames": map[string]interface{}{"enum": SupportedStores}},
+	"api.rateLimits.*.requestsPerSecond": {"minimum": 0},
+	"api.rateLimits.*.burst":             {"minimum": 0},
+	"api.cache.ttlHours":                 {"minimum": 0},
+}
+
+// Schema returns a JSON Schema describing the config file and the JSON
+// accepted by the API, with the defaults filled in.
+func Schema() map[string]interface{} {
+	schema := schemaFor(reflect.TypeOf(AppConfig{}), "", reflect.ValueOf(DefaultConfig()).Elem())
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "BeatportDL configuration"
+	return schema
+}
+
+func schemaFor(t reflect.Type, path string, def reflect.Value) map[string]interface{} {
+	schema := make(map[string]interface{})
+	switch t.Kind() {
+	case reflect.Struct:
+		properties := make(map[string]interface{}, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := yamlName(field)
+			if name == "-" {
+				continue
+			}
+			var fieldDef reflect.Value
+			if def.IsValid() {
+				fieldDef = def.Field(i)
+			}
+			properties[name] = schemaFor(field.Type, joinPath(path, name), fieldDef)
+		}
+		schema["type"] = "object"
+		schema["properties"] = properties
+		schema["additionalProperties"] = false
+	case reflect.Map:
+		schema["type"] = "object"
+		schema["additionalProperties"] = schemaFor(t.Elem(), joinPath(path, "*"), reflect.Value{})
+		if def.IsValid() && !def.IsNil() {
+			schema["default"] = def.Interface()
+		}
+	case reflect.String:
+		schema["type"] = "string"
+	case reflect.Bool:
+		schema["type"] = "boolean"
+	case reflect.Int, reflect.Int64:
+		schema["type"] = "integer"
+	case reflect.Float64:
+		schema["type"] = "number"
+	}
+
+	if t.Kind() != reflect.Struct && t.Kind() != reflect.Map && def.IsValid() {
+		schema["default"] = def.Interface()
+	}
+	if description, ok := optionDescriptions[path]; ok {
+		schema["description"] = description
+	}
+	for keyword, value := range optionConstraints[path] {
+		schema[keyword] = value
+	}
+	// A secret has no default worth showing
+	if optionConstraints[path]["writeOnly"] == true {
+		delete(schema, "default")
+	}
+	return schema
+}
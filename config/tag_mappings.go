@@ -1,24 +1,47 @@
 package config
 
 import (
-	"fmt"
+	"strings"
 
 	"github.com/unspok3n/beatportdl-ui/internal/validator"
 )
 
+func copyTagMappings(m map[string]map[string]string) map[string]map[string]string {
+	c := make(map[string]map[string]string, len(m))
+	for format, mappings := range m {
+		c[format] = make(map[string]string, len(mappings))
+		for field, tag := range mappings {
+			c[format][field] = tag
+		}
+	}
+	return c
+}
+
+// ValidateTagMappings checks that every format and field of m is
+// supported. The error is a ValidationErrors with paths below
+// tagging.mappings.
 func ValidateTagMappings(m map[string]map[string]string) error {
+	if errs := validateTagMappings("tagging.mappings", m); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validateTagMappings(path string, m map[string]map[string]string) ValidationErrors {
+	var errs ValidationErrors
 	for format, mappings := range m {
 		if !validator.PermittedValue(format, SupportedTagMappingFormats...) {
-			return fmt.Errorf("invalid tag mapping format '%s'", format)
+			errs.add(joinPath(path, format), "unsupported format, must be one of %s", strings.Join(SupportedTagMappingFormats, ", "))
+			continue
 		}
 
 		for field := range mappings {
 			if !validator.PermittedValue(field, SupportedTagMappingFields...) {
-				return fmt.Errorf("invalid tag mapping field '%s'", field)
+				errs.add(joinPath(joinPath(path, format), field), "unknown field")
 			}
 		}
 	}
-	return nil
+	return errs
 }
 
 var (
@@ -0,0 +1,28 @@
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateTagMappings(t *testing.T) {
+	if err := ValidateTagMappings(DefaultTagMappings); err != nil {
+		t.Errorf("default mappings are invalid: %v", err)
+	}
+
+	err := ValidateTagMappings(map[string]map[string]string{
+		"flac": {"track_name": "TITLE", "track_colour": "COLOUR"},
+		"mp3":  {"track_name": "TIT2"},
+	})
+	var errs ValidationErrors
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("err = %v, want two errors", err)
+	}
+	paths := map[string]bool{}
+	for _, e := range errs {
+		paths[e.Path] = true
+	}
+	if !paths["tagging.mappings.flac.track_colour"] || !paths["tagging.mappings.mp3"] {
+		t.Errorf("errors for %v", paths)
+	}
+}
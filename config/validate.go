@@ -0,0 +1,155 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// FieldError is a problem with a single option, identified by its YAML path
+// such as "downloads.retry.maxAttempts".
+type FieldError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (e *FieldError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+// ValidationErrors lists every problem found in a configuration
+type ValidationErrors []*FieldError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return "invalid config: " + strings.Join(messages, "; ")
+}
+
+func (e *ValidationErrors) add(path, format string, args ...interface{}) {
+	*e = append(*e, &FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// checkKeys reports every key of a YAML document that has no matching
+// option in AppConfig, keys set twice and values of the wrong type. It
+// returns the paths of all keys found otherwise.
+func checkKeys(data []byte) (map[string]bool, error) {
+	var root yaml.MapSlice
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	w := keyWalker{present: make(map[string]bool)}
+	w.walk(root, reflect.TypeOf(AppConfig{}), "")
+	if len(w.errs) > 0 {
+		return nil, w.errs
+	}
+	return w.present, nil
+}
+
+type keyWalker struct {
+	present map[string]bool
+	errs    ValidationErrors
+}
+
+func (w *keyWalker) walk(node interface{}, t reflect.Type, path string) {
+	if message := typeMismatch(node, t); message != "" {
+		w.errs.add(path, "%s", message)
+		return
+	}
+	items, ok := node.(yaml.MapSlice)
+	if !ok {
+		return
+	}
+	seen := make(map[string]bool, len(items))
+	for _, item := range items {
+		key := fmt.Sprint(item.Key)
+		if seen[key] {
+			w.errs.add(joinPath(path, key), "duplicate key")
+		}
+		seen[key] = true
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		fields := make(map[string]reflect.Type, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if name := yamlName(field); name != "-" {
+				fields[name] = field.Type
+			}
+		}
+		for _, item := range items {
+			key := joinPath(path, fmt.Sprint(item.Key))
+			fieldType, ok := fields[fmt.Sprint(item.Key)]
+			if !ok {
+				w.errs.add(key, "unknown key")
+				continue
+			}
+			w.present[key] = true
+			w.walk(item.Value, fieldType, key)
+		}
+	case reflect.Map:
+		for _, item := range items {
+			key := joinPath(path, fmt.Sprint(item.Key))
+			w.present[key] = true
+			w.walk(item.Value, t.Elem(), key)
+		}
+	}
+}
+
+// typeMismatch describes why a decoded YAML value can't be stored in a
+// field of type t, or returns an empty string if it can. Null leaves the
+// field as it is.
+func typeMismatch(node interface{}, t reflect.Type) string {
+	if node == nil {
+		return ""
+	}
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map:
+		if _, ok := node.(yaml.MapSlice); !ok {
+			return "must be a mapping"
+		}
+	case reflect.Bool:
+		if _, ok := node.(bool); !ok {
+			return "must be true or false"
+		}
+	case reflect.Int, reflect.Int64:
+		switch node.(type) {
+		case int, int64, uint64:
+		default:
+			return "must be an integer"
+		}
+	case reflect.Float64:
+		switch node.(type) {
+		case int, int64, uint64, float64:
+		default:
+			return "must be a number"
+		}
+	case reflect.String:
+		switch node.(type) {
+		case yaml.MapSlice, []interface{}:
+			return "must be a string"
+		}
+	}
+	return ""
+}
+
+// yamlName returns the key of a struct field in YAML documents
+func yamlName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if name == "" {
+		name = strings.ToLower(field.Name)
+	}
+	return name
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"time"
+)
+
+// Watch polls the config file every interval and passes the reloaded
+// configuration to changed whenever the content of the file changes. A
+// version that fails to load or validate is passed to rejected instead,
+// once, and the caller keeps using the previous configuration. A removed
+// file is ignored. Watch returns when ctx is done.
+func (l *Loaded) Watch(ctx context.Context, interval time.Duration, changed func(*Loaded), rejected func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var modTime time.Time
+	var size int64
+	if info, err := os.Stat(l.Path); err == nil {
+		modTime, size = info.ModTime(), info.Size()
+	}
+	last, _ := os.ReadFile(l.Path)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(l.Path)
+		if err != nil || (info.ModTime().Equal(modTime) && info.Size() == size) {
+			continue
+		}
+		modTime, size = info.ModTime(), info.Size()
+
+		data, err := os.ReadFile(l.Path)
+		if err != nil || bytes.Equal(data, last) {
+			continue
+		}
+		last = data
+
+		next, err := l.Reload()
+		if err != nil {
+			rejected(err)
+			continue
+		}
+		changed(next)
+	}
+}
@@ -4,5 +4,6 @@ go 1.24.2
 
 require (
 	github.com/google/uuid v1.6.0
+	github.com/gorilla/websocket v1.5.3
 	gopkg.in/yaml.v2 v2.4.0
 )
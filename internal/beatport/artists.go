@@ -1,6 +1,7 @@
 package beatport
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"strconv"
@@ -8,9 +9,10 @@ import (
 )
 
 type Artist struct {
-	ID   int64  `json:"id"`
-	Name string `json:"name"`
-	Slug string `json:"slug"`
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Slug  string `json:"slug"`
+	Store Store  `json:"store"`
 }
 
 type Artists []Artist
@@ -37,26 +39,18 @@ func (a *Artists) Display(limit int, shortForm string) string {
 	return artistsString
 }
 
-func (b *Beatport) GetArtist(id int64) (*Artist, error) {
-	res, err := b.fetch(
-		"GET",
-		fmt.Sprintf("/catalog/artists/%d/", id),
-		nil,
-		"",
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
+func (b *Beatport) GetArtist(ctx context.Context, id int64) (*Artist, error) {
 	response := &Artist{}
-	if err = json.NewDecoder(res.Body).Decode(response); err != nil {
+	if err := b.getCached(ctx, "artists", id, fmt.Sprintf("/catalog/artists/%d/", id), response); err != nil {
 		return nil, err
 	}
+	response.Store = b.store
 	return response, nil
 }
 
-func (b *Beatport) GetArtistTracks(id int64, page int, params string) (*Paginated[Track], error) {
+func (b *Beatport) GetArtistTracks(ctx context.Context, id int64, page int, params string) (*Paginated[Track], error) {
 	res, err := b.fetch(
+		ctx,
 		"GET",
 		fmt.Sprintf("/catalog/artists/%d/tracks/?page=%d&%s", id, page, params),
 		nil,
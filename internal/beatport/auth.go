@@ -1,11 +1,13 @@
 package beatport
 
 import (
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"hash/fnv"
+	"net/http"
 	"net/url"
 	"os"
 	"sync"
@@ -51,7 +53,25 @@ func NewAuth(username, password, cacheFile string) *Auth {
 	}
 }
 
+// SetCredentials switches the account used to log in. A token issued for
+// other credentials is dropped, so the next request logs in again.
+func (a *Auth) SetCredentials(username, password string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.username = username
+	a.password = password
+	if a.tokenPair != nil && a.tokenPair.LoginID != a.loginId() {
+		a.tokenPair = nil
+	}
+}
+
+// LoadCache restores the token written by an earlier login with the same
+// credentials.
 func (a *Auth) LoadCache() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
 	data, err := os.ReadFile(a.cacheFile)
 	if err != nil {
 		return fmt.Errorf("failed to read token file: %w", err)
@@ -72,6 +92,13 @@ func (a *Auth) LoadCache() error {
 }
 
 func (a *Auth) WriteCache() error {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.writeCache()
+}
+
+// writeCache saves the token. The caller must hold the lock.
+func (a *Auth) writeCache() error {
 	data, err := json.MarshalIndent(a.tokenPair, "", " ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal tokenPair: %w", err)
@@ -84,55 +111,116 @@ func (a *Auth) WriteCache() error {
 	return nil
 }
 
-func (a *Auth) Check(inst *Beatport) error {
-	currentTime := time.Now().Unix()
+// AuthStatus describes the login state without exposing any credentials
+type AuthStatus struct {
+	LoggedIn  bool       `json:"logged_in"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	LoginID   string     `json:"login_id,omitempty"`
+}
+
+func (a *Auth) Status() AuthStatus {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	if a.tokenPair == nil {
+		return AuthStatus{}
+	}
+	expiresAt := time.Unix(a.tokenPair.IssuedAt+a.tokenPair.ExpiresIn, 0)
+	return AuthStatus{
+		LoggedIn:  time.Now().Before(expiresAt),
+		ExpiresAt: &expiresAt,
+		LoginID:   a.tokenPair.LoginID,
+	}
+}
+
+func (a *Auth) Check(ctx context.Context, inst *Beatport) error {
 	a.mutex.RLock()
-	tokenExpirationTime := a.tokenPair.IssuedAt + a.tokenPair.ExpiresIn
+	valid := a.valid()
 	a.mutex.RUnlock()
-	if currentTime+300 >= tokenExpirationTime {
-		a.mutex.Lock()
+	if valid {
+		return nil
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	// Another request may have renewed the token while waiting for the lock
+	if a.valid() {
+		return nil
+	}
+	// Without a token there is nothing to refresh, log in right away
+	if a.tokenPair != nil {
 		fmt.Println("Refreshing token")
-		if _, err := a.refresh(inst); err != nil {
-			if err = a.Init(inst); err != nil {
-				a.mutex.Unlock()
-				return fmt.Errorf("invalid token and authorization error: %w", err)
-			}
+		if _, err := a.refresh(ctx, inst); err == nil {
+			return nil
 		}
-		a.mutex.Unlock()
+	}
+	if err := a.logIn(ctx, inst); err != nil {
+		return fmt.Errorf("invalid token and authorization error: %w", err)
 	}
 	return nil
 }
 
+// accessToken returns the current access token, or an empty string when
+// not logged in.
+func (a *Auth) accessToken() string {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	if a.tokenPair == nil {
+		return ""
+	}
+	return a.tokenPair.AccessToken
+}
+
+// valid reports whether the token is good for at least five more minutes.
+// The caller must hold the lock.
+func (a *Auth) valid() bool {
+	if a.tokenPair == nil {
+		return false
+	}
+	return time.Now().Unix()+300 < a.tokenPair.IssuedAt+a.tokenPair.ExpiresIn
+}
+
 func (a *Auth) Invalidate() {
 	a.mutex.Lock()
-	a.tokenPair.IssuedAt = 0
+	if a.tokenPair != nil {
+		a.tokenPair.IssuedAt = 0
+	}
 	a.mutex.Unlock()
 }
 
-func (a *Auth) Init(inst *Beatport) error {
+// Init logs in with the configured credentials. Requests waiting for a
+// token block until it is done.
+func (a *Auth) Init(ctx context.Context, inst *Beatport) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.logIn(ctx, inst)
+}
+
+// logIn issues a new token. The caller must hold the lock.
+func (a *Auth) logIn(ctx context.Context, inst *Beatport) error {
 	fmt.Println("Logging in")
-	sessionId, err := a.login(inst)
+	sessionId, err := a.login(ctx, inst)
 	if err != nil {
-		return fmt.Errorf("login: %v", err)
+		return fmt.Errorf("login: %w", err)
 	}
-	authorizationCode, err := a.authorize(inst, sessionId)
+	authorizationCode, err := a.authorize(ctx, inst, sessionId)
 	if err != nil {
-		return fmt.Errorf("authorize: %v", err)
+		return fmt.Errorf("authorize: %w", err)
 	}
-	if err := a.issue(inst, authorizationCode); err != nil {
-		return fmt.Errorf("issue token: %v", err)
+	if err := a.issue(ctx, inst, authorizationCode); err != nil {
+		return fmt.Errorf("issue token: %w", err)
 	}
 	return nil
 }
 
-func (a *Auth) refresh(inst *Beatport) (*tokenPair, error) {
+func (a *Auth) refresh(ctx context.Context, inst *Beatport) (*tokenPair, error) {
 	payload := map[string]string{
 		"client_id":     clientId,
 		"refresh_token": a.tokenPair.RefreshToken,
 		"grant_type":    "refresh_token",
 	}
 
-	res, err := inst.fetch("POST", tokenEndpoint, payload, "application/x-www-form-urlencoded")
+	res, err := inst.fetch(ctx, "POST", tokenEndpoint, payload, "application/x-www-form-urlencoded")
 	if err != nil {
 		return nil, err
 	}
@@ -145,14 +233,14 @@ func (a *Auth) refresh(inst *Beatport) (*tokenPair, error) {
 	a.tokenPair = response
 	a.tokenPair.IssuedAt = time.Now().Unix()
 	a.tokenPair.LoginID = loginId
-	if err = a.WriteCache(); err != nil {
+	if err = a.writeCache(); err != nil {
 		return nil, err
 	}
 
 	return response, nil
 }
 
-func (a *Auth) issue(inst *Beatport, code string) error {
+func (a *Auth) issue(ctx context.Context, inst *Beatport, code string) error {
 	payload := map[string]string{
 		"client_id": clientId,
 	}
@@ -166,7 +254,7 @@ func (a *Auth) issue(inst *Beatport, code string) error {
 		payload["password"] = a.password
 	}
 
-	res, err := inst.fetch("POST", tokenEndpoint, payload, "application/x-www-form-urlencoded")
+	res, err := inst.fetch(ctx, "POST", tokenEndpoint, payload, "application/x-www-form-urlencoded")
 	if err != nil {
 		return err
 	}
@@ -178,7 +266,7 @@ func (a *Auth) issue(inst *Beatport, code string) error {
 	a.tokenPair = response
 	a.tokenPair.IssuedAt = time.Now().Unix()
 	a.tokenPair.LoginID = a.loginId()
-	err = a.WriteCache()
+	err = a.writeCache()
 	if err != nil {
 		return err
 	}
@@ -186,10 +274,11 @@ func (a *Auth) issue(inst *Beatport, code string) error {
 	return nil
 }
 
-func (a *Auth) authorize(inst *Beatport, sessionId string) (string, error) {
-	inst.headers["cookie"] = fmt.Sprintf("sessionid=%s", sessionId)
-	res, err := inst.fetch("GET", authEndpoint, nil, "")
-	delete(inst.headers, "cookie")
+func (a *Auth) authorize(ctx context.Context, inst *Beatport, sessionId string) (string, error) {
+	// The session cookie only goes with this request, the headers of the
+	// client are shared with requests running meanwhile
+	header := http.Header{"Cookie": {fmt.Sprintf("sessionid=%s", sessionId)}}
+	res, err := inst.fetchWithHeader(ctx, "GET", authEndpoint, nil, "", header)
 	if err != nil {
 		return "", err
 	}
@@ -209,13 +298,13 @@ func (a *Auth) authorize(inst *Beatport, sessionId string) (string, error) {
 	return "", ErrInvalidAuthorizationCode
 }
 
-func (a *Auth) login(inst *Beatport) (string, error) {
+func (a *Auth) login(ctx context.Context, inst *Beatport) (string, error) {
 	payload := map[string]string{
 		"username": a.username,
 		"password": a.password,
 	}
 
-	res, err := inst.fetch("POST", loginEndpoint, payload, "application/json")
+	res, err := inst.fetch(ctx, "POST", loginEndpoint, payload, "application/json")
 	if err != nil {
 		return "", err
 	}
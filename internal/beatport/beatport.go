@@ -2,27 +2,49 @@ package beatport
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"time"
+
+	"github.com/unspok3n/beatportdl-ui/internal/ratelimit"
 )
 
 const (
 	beatportBaseUrl   = "https://api.beatport.com/v4"
 	beatsourceBaseUrl = "https://api.beatsource.com/v4"
+
+	maxFetchAttempts = 4
+	// maxRetryAfter is the longest Retry-After that is waited out, longer
+	// ones fail right away with ErrRateLimited
+	maxRetryAfter = 2 * time.Minute
 )
 
+// fetchRetryDelay is the backoff before the second attempt of a request,
+// doubled for every further one
+var fetchRetryDelay = time.Second
+
 type Beatport struct {
 	store   Store
 	client  *http.Client
 	headers map[string]string
 	auth    *Auth
+	limiter *ratelimit.Limiter
+	cache   *Cache
 }
 
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrRateLimited  = errors.New("rate limited")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrNotAvailable = errors.New("not available")
+)
+
 type ServerError struct {
 	Code    int
 	Message string
@@ -32,6 +54,22 @@ func (e *ServerError) Error() string {
 	return fmt.Sprintf("code: %d, message: %s", e.Code, e.Message)
 }
 
+// Unwrap lets callers tell errors apart with errors.Is, e.g.
+// errors.Is(err, ErrNotFound).
+func (e *ServerError) Unwrap() error {
+	switch e.Code {
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	case http.StatusForbidden, http.StatusUnavailableForLegalReasons:
+		return ErrNotAvailable
+	}
+	return nil
+}
+
 type Paginated[T any] struct {
 	Next     *string `json:"next"`
 	Previous *string `json:"previous"`
@@ -69,19 +107,34 @@ func New(store Store, proxyUrl string, auth *Auth) *Beatport {
 	return &f
 }
 
-func (b *Beatport) fetch(method, endpoint string, payload interface{}, contentType string) (*http.Response, error) {
-	var body bytes.Buffer
+// SetLimiter makes every request of the client wait for the limiter. A
+// limiter can be shared between clients.
+func (b *Beatport) SetLimiter(limiter *ratelimit.Limiter) {
+	b.limiter = limiter
+}
 
-	if endpoint != tokenEndpoint && endpoint != authEndpoint && endpoint != loginEndpoint {
-		if err := b.auth.Check(b); err != nil {
-			return nil, err
-		}
-	}
+// SetTransport sends the requests of the client through transport, e.g.
+// to point it at a test server.
+func (b *Beatport) SetTransport(transport http.RoundTripper) {
+	b.client.Transport = transport
+}
+
+func (b *Beatport) fetch(ctx context.Context, method, endpoint string, payload interface{}, contentType string) (*http.Response, error) {
+	return b.fetchWithHeader(ctx, method, endpoint, payload, contentType, nil)
+}
+
+// fetchWithHeader is fetch with extra request headers. A conditional
+// request with If-None-Match also accepts a 304 Not Modified response.
+func (b *Beatport) fetchWithHeader(ctx context.Context, method, endpoint string, payload interface{}, contentType string, header http.Header) (*http.Response, error) {
+	var body []byte
+
+	authenticated := endpoint != tokenEndpoint && endpoint != authEndpoint && endpoint != loginEndpoint
 
 	if payload != nil {
+		var buf bytes.Buffer
 		switch contentType {
 		case "application/json":
-			if err := json.NewEncoder(&body).Encode(payload); err != nil {
+			if err := json.NewEncoder(&buf).Encode(payload); err != nil {
 				return nil, &ServerError{Code: http.StatusBadRequest, Message: fmt.Sprintf("failed to encode json payload: %v", err)}
 			}
 		case "application/x-www-form-urlencoded":
@@ -89,10 +142,11 @@ func (b *Beatport) fetch(method, endpoint string, payload interface{}, contentTy
 			if err != nil {
 				return nil, fmt.Errorf("failed to encode form payload: %w", err)
 			}
-			body.WriteString(formData.Encode())
+			buf.WriteString(formData.Encode())
 		default:
 			return nil, &ServerError{Code: http.StatusBadRequest, Message: fmt.Sprintf("unsupported content type: %s", contentType)}
 		}
+		body = buf.Bytes()
 	}
 
 	var baseUrl string
@@ -103,43 +157,128 @@ func (b *Beatport) fetch(method, endpoint string, payload interface{}, contentTy
 		baseUrl = beatsourceBaseUrl
 	}
 
-	req, err := http.NewRequest(method, baseUrl+endpoint, &body)
-	if err != nil {
-		return nil, &ServerError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("failed to create request: %v", err)}
-	}
+	// Requests that change something are sent once, a lost answer doesn't
+	// mean the server didn't act on them
+	idempotent := method == http.MethodGet || method == http.MethodHead
 
-	for key, value := range b.headers {
-		req.Header.Add(key, value)
-	}
+	reauthenticated := false
+	for attempt := 1; ; attempt++ {
+		if authenticated {
+			if err := b.auth.Check(ctx, b); err != nil {
+				return nil, err
+			}
+		}
 
-	if payload != nil {
-		req.Header.Set("Content-Type", contentType)
-	}
+		if err := b.limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
 
-	if b.auth.tokenPair != nil {
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", b.auth.tokenPair.AccessToken))
-	}
+		req, err := http.NewRequestWithContext(ctx, method, baseUrl+endpoint, bytes.NewReader(body))
+		if err != nil {
+			return nil, &ServerError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("failed to create request: %v", err)}
+		}
 
-	resp, err := b.client.Do(req)
-	if err != nil {
-		return nil, &ServerError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("request failed: %v", err)}
-	}
+		for key, value := range b.headers {
+			req.Header.Add(key, value)
+		}
+
+		for key, values := range header {
+			req.Header[key] = values
+		}
+
+		if payload != nil {
+			req.Header.Set("Content-Type", contentType)
+		}
+
+		if authenticated {
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", b.auth.accessToken()))
+		}
+
+		resp, err := b.client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, context.Cause(ctx)
+			}
+			if idempotent && attempt < maxFetchAttempts {
+				if err := sleep(ctx, retryDelay(attempt)); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			return nil, &ServerError{Code: http.StatusInternalServerError, Message: fmt.Sprintf("request failed: %v", err)}
+		}
+
+		if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusFound {
+			return resp, nil
+		}
+		if resp.StatusCode == http.StatusNotModified && header.Get("If-None-Match") != "" {
+			return resp, nil
+		}
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusFound {
-		if resp.StatusCode == http.StatusUnauthorized && endpoint != tokenEndpoint && endpoint != authEndpoint && endpoint != loginEndpoint {
+		// The token may have been revoked, log in again but only once so a
+		// disabled account doesn't loop forever
+		if resp.StatusCode == http.StatusUnauthorized && authenticated && !reauthenticated {
+			resp.Body.Close()
 			b.auth.Invalidate()
-			return b.fetch(method, endpoint, payload, contentType)
+			reauthenticated = true
+			continue
 		}
-		body, err := readResponseBody(resp)
+
+		retryable := resp.StatusCode == http.StatusTooManyRequests || (idempotent && resp.StatusCode >= http.StatusInternalServerError)
+		if retryable && attempt < maxFetchAttempts {
+			delay, ok := retryAfter(resp.Header.Get("Retry-After"))
+			if !ok {
+				delay = retryDelay(attempt)
+			}
+			if delay <= maxRetryAfter {
+				resp.Body.Close()
+				if err := sleep(ctx, delay); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+
+		respBody, err := readResponseBody(resp)
 		resp.Body.Close()
 		message := fmt.Sprintf("request failed with status code: %d", resp.StatusCode)
-		if err == nil && body != "" {
-			message += fmt.Sprintf(", response body: %s", body)
+		if err == nil && respBody != "" {
+			message += fmt.Sprintf(", response body: %s", respBody)
 		}
 		return nil, &ServerError{Code: resp.StatusCode, Message: message}
 	}
+}
+
+// retryDelay returns the backoff before the next attempt when the server
+// did not ask for a specific delay.
+func retryDelay(attempt int) time.Duration {
+	return fetchRetryDelay << (attempt - 1)
+}
+
+// retryAfter parses a Retry-After header given either in seconds or as an
+// HTTP date.
+func retryAfter(header string) (time.Duration, bool) {
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		return max(time.Until(date), 0), true
+	}
+	return 0, false
+}
 
-	return resp, nil
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	case <-timer.C:
+		return nil
+	}
 }
 
 func encodeFormPayload(payload interface{}) (url.Values, error) {
@@ -0,0 +1,238 @@
+package beatport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// scriptedResponse is one answer of a scriptedServer
+type scriptedResponse struct {
+	code       int
+	retryAfter string
+	// drop closes the connection without answering
+	drop bool
+}
+
+// scriptedServer answers catalog requests with the scripted responses in
+// order, repeating the last one, and issues a fresh token on the token
+// endpoint. It records the token every catalog request was made with.
+type scriptedServer struct {
+	responses []scriptedResponse
+	mutex     sync.Mutex
+	tokens    []string
+	refreshes int
+}
+
+func (s *scriptedServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if r.URL.Path == "/v4"+tokenEndpoint {
+		s.refreshes++
+		w.Write([]byte(`{"access_token": "fresh", "refresh_token": "refresh", "expires_in": 3600}`))
+		return
+	}
+
+	s.tokens = append(s.tokens, r.Header.Get("Authorization"))
+	response := s.responses[min(len(s.tokens), len(s.responses))-1]
+	if response.drop {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+		return
+	}
+	if response.retryAfter != "" {
+		w.Header().Set("Retry-After", response.retryAfter)
+	}
+	w.WriteHeader(response.code)
+	if response.code == http.StatusOK {
+		w.Write([]byte(`{"id": 1}`))
+	}
+}
+
+func TestFetch(t *testing.T) {
+	defer func(d time.Duration) { fetchRetryDelay = d }(fetchRetryDelay)
+	fetchRetryDelay = time.Millisecond
+
+	tests := []struct {
+		name string
+		// method defaults to GET
+		method    string
+		responses []scriptedResponse
+		// err is the error expected from fetch, code its status code
+		err       error
+		code      int
+		requests  int
+		refreshes int
+	}{
+		{
+			name:      "success",
+			responses: []scriptedResponse{{code: http.StatusOK}},
+			requests:  1,
+		},
+		{
+			name:      "revoked token is renewed once",
+			responses: []scriptedResponse{{code: http.StatusUnauthorized}, {code: http.StatusOK}},
+			requests:  2,
+			refreshes: 1,
+		},
+		{
+			name:      "second 401 gives up",
+			responses: []scriptedResponse{{code: http.StatusUnauthorized}},
+			err:       ErrUnauthorized,
+			code:      http.StatusUnauthorized,
+			requests:  2,
+			refreshes: 1,
+		},
+		{
+			name:      "rate limit waits for Retry-After",
+			responses: []scriptedResponse{{code: http.StatusTooManyRequests, retryAfter: "0"}, {code: http.StatusOK}},
+			requests:  2,
+		},
+		{
+			name:      "long Retry-After fails right away",
+			responses: []scriptedResponse{{code: http.StatusTooManyRequests, retryAfter: "3600"}},
+			err:       ErrRateLimited,
+			code:      http.StatusTooManyRequests,
+			requests:  1,
+		},
+		{
+			name:      "server errors are retried",
+			responses: []scriptedResponse{{code: http.StatusBadGateway}, {code: http.StatusServiceUnavailable}, {code: http.StatusOK}},
+			requests:  3,
+		},
+		{
+			name:      "attempts are bounded",
+			responses: []scriptedResponse{{code: http.StatusInternalServerError}},
+			code:      http.StatusInternalServerError,
+			requests:  maxFetchAttempts,
+		},
+		{
+			name:      "dropped connections are retried",
+			responses: []scriptedResponse{{drop: true}, {code: http.StatusOK}},
+			requests:  2,
+		},
+		{
+			name:      "POST is not retried after a dropped connection",
+			method:    http.MethodPost,
+			responses: []scriptedResponse{{drop: true}, {code: http.StatusOK}},
+			code:      http.StatusInternalServerError,
+			requests:  1,
+		},
+		{
+			name:      "POST is not retried after a server error",
+			method:    http.MethodPost,
+			responses: []scriptedResponse{{code: http.StatusBadGateway}, {code: http.StatusOK}},
+			code:      http.StatusBadGateway,
+			requests:  1,
+		},
+		{
+			name:      "POST is retried when rate limited",
+			method:    http.MethodPost,
+			responses: []scriptedResponse{{code: http.StatusTooManyRequests, retryAfter: "0"}, {code: http.StatusOK}},
+			requests:  2,
+		},
+		{
+			name:      "not found",
+			responses: []scriptedResponse{{code: http.StatusNotFound}},
+			err:       ErrNotFound,
+			code:      http.StatusNotFound,
+			requests:  1,
+		},
+		{
+			name:      "not available",
+			responses: []scriptedResponse{{code: http.StatusForbidden}},
+			err:       ErrNotAvailable,
+			code:      http.StatusForbidden,
+			requests:  1,
+		},
+		{
+			name:      "bad request is final",
+			responses: []scriptedResponse{{code: http.StatusBadRequest}},
+			code:      http.StatusBadRequest,
+			requests:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &scriptedServer{responses: tt.responses}
+			b := newTestClient(t, srv)
+			b.auth.cacheFile = filepath.Join(t.TempDir(), "token.json")
+
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			resp, err := b.fetch(context.Background(), method, "/catalog/tracks/1/", nil, "")
+			if resp != nil {
+				resp.Body.Close()
+			}
+
+			var serverErr *ServerError
+			switch {
+			case tt.code == 0 && err != nil:
+				t.Fatalf("fetch: %v", err)
+			case tt.code != 0 && (!errors.As(err, &serverErr) || serverErr.Code != tt.code):
+				t.Fatalf("fetch = %v, want status code %d", err, tt.code)
+			case tt.err != nil && !errors.Is(err, tt.err):
+				t.Fatalf("fetch = %v, want %v", err, tt.err)
+			}
+
+			if len(srv.tokens) != tt.requests || srv.refreshes != tt.refreshes {
+				t.Errorf("%d requests and %d refreshes, want %d and %d", len(srv.tokens), srv.refreshes, tt.requests, tt.refreshes)
+			}
+			// A renewed token is used for the request after it
+			if tt.refreshes > 0 && srv.tokens[len(srv.tokens)-1] != "Bearer fresh" {
+				t.Errorf("retried with %q, want the renewed token", srv.tokens[len(srv.tokens)-1])
+			}
+		})
+	}
+}
+
+func TestFetchCancelledWhileWaiting(t *testing.T) {
+	srv := &scriptedServer{responses: []scriptedResponse{{code: http.StatusTooManyRequests, retryAfter: "60"}}}
+	b := newTestClient(t, srv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := b.fetch(ctx, http.MethodGet, "/catalog/tracks/1/", nil, ""); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("fetch = %v, want context.DeadlineExceeded", err)
+	}
+	if len(srv.tokens) != 1 {
+		t.Errorf("%d requests, want 1", len(srv.tokens))
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		header string
+		want   time.Duration
+		ok     bool
+	}{
+		{header: ""},
+		{header: "soon"},
+		{header: "-1"},
+		{header: "0", ok: true},
+		{header: "30", want: 30 * time.Second, ok: true},
+		{header: time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), ok: true},
+	}
+	for _, tt := range tests {
+		got, ok := retryAfter(tt.header)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("retryAfter(%q) = %v, %v; want %v, %v", tt.header, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	// A date in the future waits until then
+	date := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if got, ok := retryAfter(date); !ok || got < 59*time.Minute || got > time.Hour {
+		t.Errorf("retryAfter(%q) = %v, %v; want about an hour", date, got, ok)
+	}
+}
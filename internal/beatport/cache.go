@@ -0,0 +1,215 @@
+package beatport
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Cache keeps catalog responses on disk, one file per store, entity and
+// ID. Entries expire after the max-age the API sends in Cache-Control, or
+// after the default TTL when it sends none. Expired entries with an ETag
+// are revalidated with a conditional request instead of fetched again.
+type Cache struct {
+	dir         string
+	ttl         time.Duration
+	hits        atomic.Int64
+	misses      atomic.Int64
+	revalidated atomic.Int64
+}
+
+type cacheEntry struct {
+	ETag    string          `json:"etag,omitempty"`
+	Expires time.Time       `json:"expires"`
+	Body    json.RawMessage `json:"body"`
+}
+
+// CacheStats counts the lookups since the cache was created.
+type CacheStats struct {
+	Hits        int64 `json:"hits"`
+	Misses      int64 `json:"misses"`
+	Revalidated int64 `json:"revalidated"`
+	Entries     int   `json:"entries"`
+}
+
+// NewCache returns a cache storing its entries in dir.
+func NewCache(dir string, ttl time.Duration) *Cache {
+	return &Cache{
+		dir: dir,
+		ttl: ttl,
+	}
+}
+
+// SetCache makes the client keep catalog lookups in the cache. A cache can
+// be shared between clients.
+func (b *Beatport) SetCache(cache *Cache) {
+	b.cache = cache
+}
+
+func (c *Cache) path(store Store, entity string, id int64) string {
+	return filepath.Join(c.dir, string(store), entity, strconv.FormatInt(id, 10)+".json")
+}
+
+func (c *Cache) load(path string) *cacheEntry {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil
+	}
+	return &entry
+}
+
+func (c *Cache) save(path string, entry *cacheEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// Written to a temporary file first so readers never see half an entry
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Stats returns the hit and miss counters and the number of entries on
+// disk.
+func (c *Cache) Stats() CacheStats {
+	stats := CacheStats{
+		Hits:        c.hits.Load(),
+		Misses:      c.misses.Load(),
+		Revalidated: c.revalidated.Load(),
+	}
+	entries, _ := c.entries()
+	stats.Entries = len(entries)
+	return stats
+}
+
+// entries lists the entry files, leaving out anything else kept in the
+// cache directory.
+func (c *Cache) entries() ([]string, error) {
+	return filepath.Glob(filepath.Join(c.dir, "*", "*", "*.json"))
+}
+
+// Purge removes every entry and returns how many were removed. The cache
+// directory itself and files that aren't entries are kept.
+func (c *Cache) Purge() (int, error) {
+	entries, err := c.entries()
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, path := range entries {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// expiry returns when a response stops being fresh according to its
+// Cache-Control header. It reports false when the response must not be
+// stored at all.
+func (c *Cache) expiry(header http.Header) (time.Time, bool) {
+	now := time.Now()
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(strings.ToLower(directive)), "=")
+		switch name {
+		case "no-store":
+			return time.Time{}, false
+		case "no-cache":
+			return now, true
+		case "max-age":
+			if seconds, err := strconv.Atoi(value); err == nil {
+				return now.Add(time.Duration(seconds) * time.Second), true
+			}
+		}
+	}
+	return now.Add(c.ttl), true
+}
+
+// getCached decodes the catalog entity at endpoint into v, going through
+// the cache when the client has one.
+func (b *Beatport) getCached(ctx context.Context, entity string, id int64, endpoint string, v interface{}) error {
+	c := b.cache
+	if c == nil {
+		res, err := b.fetch(ctx, "GET", endpoint, nil, "")
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		return json.NewDecoder(res.Body).Decode(v)
+	}
+
+	path := c.path(b.store, entity, id)
+	entry := c.load(path)
+	if entry != nil && time.Now().Before(entry.Expires) {
+		if err := json.Unmarshal(entry.Body, v); err == nil {
+			c.hits.Add(1)
+			return nil
+		}
+		entry = nil
+	}
+
+	header := http.Header{}
+	if entry != nil && entry.ETag != "" {
+		header.Set("If-None-Match", entry.ETag)
+	}
+	res, err := b.fetchWithHeader(ctx, "GET", endpoint, nil, "", header)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	expires, cacheable := c.expiry(res.Header)
+	if res.StatusCode == http.StatusNotModified {
+		c.revalidated.Add(1)
+		entry.Expires = expires
+		if cacheable {
+			c.save(path, entry)
+		}
+		return json.Unmarshal(entry.Body, v)
+	}
+
+	c.misses.Add(1)
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return err
+	}
+	if !cacheable {
+		os.Remove(path)
+		return nil
+	}
+	// An entry that can't be written only costs another request next time
+	c.save(path, &cacheEntry{
+		ETag:    res.Header.Get("ETag"),
+		Expires: expires,
+		Body:    body,
+	})
+	return nil
+}
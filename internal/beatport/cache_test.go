@@ -0,0 +1,191 @@
+package beatport
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// redirectTransport sends every request to a test server instead of the
+// store's API
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (t redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = t.target.Scheme
+	req.URL.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// newTestClient returns a logged in client talking to handler
+func newTestClient(t *testing.T, handler http.Handler) *Beatport {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	target, _ := url.Parse(srv.URL)
+
+	auth := NewAuth("user", "password", "")
+	auth.tokenPair = &tokenPair{AccessToken: "token", ExpiresIn: 3600, IssuedAt: time.Now().Unix()}
+	b := New(StoreBeatport, "", auth)
+	b.SetTransport(redirectTransport{target: target})
+	return b
+}
+
+// catalogServer answers with a fixed track and counts the requests, both
+// in full and conditional ones answered with 304
+type catalogServer struct {
+	etag         string
+	cacheControl string
+	requests     atomic.Int32
+	notModified  atomic.Int32
+}
+
+func (s *catalogServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.requests.Add(1)
+	if s.etag != "" {
+		w.Header().Set("ETag", s.etag)
+		if r.Header.Get("If-None-Match") == s.etag {
+			s.notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	if s.cacheControl != "" {
+		w.Header().Set("Cache-Control", s.cacheControl)
+	}
+	w.Write([]byte(`{"id": 1, "name": "Track"}`))
+}
+
+func TestCache(t *testing.T) {
+	tests := []struct {
+		name         string
+		etag         string
+		cacheControl string
+		ttl          time.Duration
+		// wait is slept before the second lookup
+		wait        time.Duration
+		requests    int32
+		notModified int32
+		stats       CacheStats
+	}{
+		{
+			name:     "fresh entry is a hit",
+			etag:     `"v1"`,
+			ttl:      time.Hour,
+			requests: 1,
+			stats:    CacheStats{Hits: 1, Misses: 1, Entries: 1},
+		},
+		{
+			name:         "max-age overrides the TTL",
+			etag:         `"v1"`,
+			cacheControl: "max-age=0",
+			ttl:          time.Hour,
+			requests:     2,
+			notModified:  1,
+			stats:        CacheStats{Misses: 1, Revalidated: 1, Entries: 1},
+		},
+		{
+			name:        "expired entry is revalidated",
+			etag:        `"v1"`,
+			ttl:         20 * time.Millisecond,
+			wait:        50 * time.Millisecond,
+			requests:    2,
+			notModified: 1,
+			stats:       CacheStats{Misses: 1, Revalidated: 1, Entries: 1},
+		},
+		{
+			name:     "expired entry without an ETag is fetched again",
+			ttl:      20 * time.Millisecond,
+			wait:     50 * time.Millisecond,
+			requests: 2,
+			stats:    CacheStats{Misses: 2, Entries: 1},
+		},
+		{
+			name:         "no-store is not kept",
+			etag:         `"v1"`,
+			cacheControl: "no-store",
+			ttl:          time.Hour,
+			requests:     2,
+			stats:        CacheStats{Misses: 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &catalogServer{etag: tt.etag, cacheControl: tt.cacheControl}
+			b := newTestClient(t, srv)
+			cache := NewCache(t.TempDir(), tt.ttl)
+			b.SetCache(cache)
+
+			for i := 0; i < 2; i++ {
+				if i == 1 {
+					time.Sleep(tt.wait)
+				}
+				var track struct {
+					ID   int64  `json:"id"`
+					Name string `json:"name"`
+				}
+				if err := b.getCached(context.Background(), "tracks", 1, "/catalog/tracks/1/", &track); err != nil {
+					t.Fatalf("lookup %d: %v", i+1, err)
+				}
+				if track.ID != 1 || track.Name != "Track" {
+					t.Errorf("lookup %d decoded %+v", i+1, track)
+				}
+			}
+
+			if n := srv.requests.Load(); n != tt.requests {
+				t.Errorf("%d requests, want %d", n, tt.requests)
+			}
+			if n := srv.notModified.Load(); n != tt.notModified {
+				t.Errorf("%d answered with 304, want %d", n, tt.notModified)
+			}
+			if stats := cache.Stats(); stats != tt.stats {
+				t.Errorf("stats = %+v, want %+v", stats, tt.stats)
+			}
+		})
+	}
+}
+
+func TestCachePurge(t *testing.T) {
+	b := newTestClient(t, &catalogServer{})
+	dir := t.TempDir()
+	cache := NewCache(dir, time.Hour)
+	b.SetCache(cache)
+
+	// Files that aren't entries survive a purge, wherever they are
+	foreign := []string{filepath.Join(dir, "settings.json"), filepath.Join(dir, "beatport", "notes.txt")}
+	for _, path := range foreign {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, id := range []int64{1, 2, 3} {
+		var v map[string]interface{}
+		if err := b.getCached(context.Background(), "tracks", id, "/catalog/tracks/1/", &v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	removed, err := cache.Purge()
+	if err != nil || removed != 3 {
+		t.Errorf("Purge = %d, %v; want 3", removed, err)
+	}
+	if entries := cache.Stats().Entries; entries != 0 {
+		t.Errorf("%d entries left after purging", entries)
+	}
+	for _, path := range foreign {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("purge removed %s: %v", path, err)
+		}
+	}
+}
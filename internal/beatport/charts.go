@@ -1,6 +1,7 @@
 package beatport
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"strconv"
@@ -18,6 +19,7 @@ type Chart struct {
 	ChangeDate  time.Time   `json:"change_date"`
 	PublishDate time.Time   `json:"publish_date"`
 	Image       Image       `json:"image"`
+	Store       Store       `json:"store"`
 }
 
 type ChartPerson struct {
@@ -45,26 +47,18 @@ func (c *Chart) DirectoryName(n NamingPreferences) string {
 	return SanitizePath(directoryName, n.Whitespace)
 }
 
-func (b *Beatport) GetChart(id int64) (*Chart, error) {
-	res, err := b.fetch(
-		"GET",
-		fmt.Sprintf("/catalog/charts/%d/", id),
-		nil,
-		"",
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
+func (b *Beatport) GetChart(ctx context.Context, id int64) (*Chart, error) {
 	response := &Chart{}
-	if err = json.NewDecoder(res.Body).Decode(response); err != nil {
+	if err := b.getCached(ctx, "charts", id, fmt.Sprintf("/catalog/charts/%d/", id), response); err != nil {
 		return nil, err
 	}
+	response.Store = b.store
 	return response, nil
 }
 
-func (b *Beatport) GetChartTracks(id int64, page int, params string) (*Paginated[Track], error) {
+func (b *Beatport) GetChartTracks(ctx context.Context, id int64, page int, params string) (*Paginated[Track], error) {
 	res, err := b.fetch(
+		ctx,
 		"GET",
 		fmt.Sprintf("/catalog/charts/%d/tracks/?page=%d&%s", id, page, params),
 		nil,
@@ -0,0 +1,94 @@
+package beatport
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"path/filepath"
+)
+
+// CollectionTrack is a track found while expanding a collection, along
+// with the directory it belongs in.
+type CollectionTrack struct {
+	Track     Track
+	Directory string
+}
+
+// Expand resolves the collection behind link into its directory name and
+// the full list of its tracks. naming returns the naming preferences for
+// the directory of each kind of collection.
+func (b *Beatport) Expand(ctx context.Context, link *Link, naming func(LinkType) NamingPreferences, opts PageOptions) (string, []CollectionTrack, error) {
+	var directory string
+	var tracks []CollectionTrack
+	collect := func(items iter.Seq2[Track, error], directory string) error {
+		for track, err := range items {
+			if err != nil {
+				return err
+			}
+			tracks = append(tracks, CollectionTrack{Track: track, Directory: directory})
+		}
+		return nil
+	}
+
+	switch link.Type {
+	case ReleaseLink:
+		release, err := b.GetRelease(ctx, link.ID)
+		if err != nil {
+			return "", nil, err
+		}
+		directory = release.DirectoryName(naming(ReleaseLink))
+		if err := collect(b.ReleaseTracks(ctx, link.ID, link.Params, opts), directory); err != nil {
+			return "", nil, err
+		}
+	case PlaylistLink:
+		playlist, err := b.GetPlaylist(ctx, link.ID)
+		if err != nil {
+			return "", nil, err
+		}
+		directory = playlist.DirectoryName(naming(PlaylistLink))
+		for item, err := range b.PlaylistItems(ctx, link.ID, link.Params, opts) {
+			if err != nil {
+				return "", nil, err
+			}
+			tracks = append(tracks, CollectionTrack{Track: item.Track, Directory: directory})
+		}
+	case ChartLink:
+		chart, err := b.GetChart(ctx, link.ID)
+		if err != nil {
+			return "", nil, err
+		}
+		directory = chart.DirectoryName(naming(ChartLink))
+		if err := collect(b.ChartTracks(ctx, link.ID, link.Params, opts), directory); err != nil {
+			return "", nil, err
+		}
+	case ArtistLink:
+		artist, err := b.GetArtist(ctx, link.ID)
+		if err != nil {
+			return "", nil, err
+		}
+		directory = artist.DirectoryName(naming(ArtistLink))
+		if err := collect(b.ArtistTracks(ctx, link.ID, link.Params, opts), directory); err != nil {
+			return "", nil, err
+		}
+	case LabelLink:
+		label, err := b.GetLabel(ctx, link.ID)
+		if err != nil {
+			return "", nil, err
+		}
+		directory = label.DirectoryName(naming(LabelLink))
+		// Label tracks are grouped in a directory per release
+		for release, err := range b.LabelReleases(ctx, link.ID, link.Params, opts) {
+			if err != nil {
+				return "", nil, err
+			}
+			releaseDirectory := filepath.Join(directory, release.DirectoryName(naming(ReleaseLink)))
+			if err := collect(b.ReleaseTracks(ctx, release.ID, "", opts), releaseDirectory); err != nil {
+				return "", nil, err
+			}
+		}
+	default:
+		return "", nil, fmt.Errorf("unsupported collection type: %s", link.Type)
+	}
+
+	return directory, tracks, nil
+}
@@ -1,6 +1,7 @@
 package beatport
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"strconv"
@@ -32,27 +33,18 @@ func (l *Label) StoreUrl() string {
 	return storeUrl(l.ID, "label", l.Slug, l.Store)
 }
 
-func (b *Beatport) GetLabel(id int64) (*Label, error) {
-	res, err := b.fetch(
-		"GET",
-		fmt.Sprintf("/catalog/labels/%d/", id),
-		nil,
-		"",
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
+func (b *Beatport) GetLabel(ctx context.Context, id int64) (*Label, error) {
 	response := &Label{}
-	if err = json.NewDecoder(res.Body).Decode(response); err != nil {
+	if err := b.getCached(ctx, "labels", id, fmt.Sprintf("/catalog/labels/%d/", id), response); err != nil {
 		return nil, err
 	}
 	response.Store = b.store
 	return response, nil
 }
 
-func (b *Beatport) GetLabelReleases(id int64, page int, params string) (*Paginated[Release], error) {
+func (b *Beatport) GetLabelReleases(ctx context.Context, id int64, page int, params string) (*Paginated[Release], error) {
 	res, err := b.fetch(
+		ctx,
 		"GET",
 		fmt.Sprintf("/catalog/labels/%d/releases/?page=%d&%s", id, page, params),
 		nil,
@@ -80,6 +80,9 @@ func ParseUrl(inputURL string) (*Link, error) {
 		idSegment = 2
 		link.Type = ReleaseLink
 	case "library":
+		if segmentsLength < 2 {
+			return nil, ErrInvalidUrl
+		}
 		switch segments[1] {
 		case "playlists", "playlist":
 			idSegment = 2
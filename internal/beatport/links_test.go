@@ -0,0 +1,55 @@
+package beatport
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseUrl(t *testing.T) {
+	tests := []struct {
+		url    string
+		store  Store
+		typ    LinkType
+		id     int64
+		params string
+	}{
+		{url: "https://www.beatport.com/track/song/17", store: StoreBeatport, typ: TrackLink, id: 17},
+		{url: "https://www.beatport.com/de/track/song/17", store: StoreBeatport, typ: TrackLink, id: 17},
+		{url: "https://www.beatport.com/fr/release/the-ep/5?page=2", store: StoreBeatport, typ: ReleaseLink, id: 5, params: "page=2"},
+		{url: "https://www.beatport.com/en/chart/top-10/7", store: StoreBeatport, typ: ChartLink, id: 7},
+		{url: "https://www.beatport.com/es/label/label/9/", store: StoreBeatport, typ: LabelLink, id: 9},
+		{url: "https://api.beatport.com/v4/catalog/tracks/17/", store: StoreBeatport, typ: TrackLink, id: 17},
+		{url: "https://www.beatsource.com/track/song/17", store: StoreBeatsource, typ: TrackLink, id: 17},
+		{url: "https://www.beatsource.com/pt/release/the-ep/5", store: StoreBeatsource, typ: ReleaseLink, id: 5},
+		{url: "https://www.beatsource.com/es/playlist/open-format/3", store: StoreBeatsource, typ: ChartLink, id: 3},
+		{url: "https://www.beatsource.com/library/playlists/4", store: StoreBeatsource, typ: PlaylistLink, id: 4},
+		{url: "https://www.beatsource.com/de/artist/one/8", store: StoreBeatsource, typ: ArtistLink, id: 8},
+		{url: "https://api.beatsource.com/v4/catalog/releases/5/", store: StoreBeatsource, typ: ReleaseLink, id: 5},
+	}
+
+	for _, tt := range tests {
+		link, err := ParseUrl(tt.url)
+		if err != nil {
+			t.Errorf("ParseUrl(%q): %v", tt.url, err)
+			continue
+		}
+		if link.Store != tt.store || link.Type != tt.typ || link.ID != tt.id || link.Params != tt.params {
+			t.Errorf("ParseUrl(%q) = %s %s %d %q, want %s %s %d %q", tt.url, link.Store, link.Type, link.ID, link.Params, tt.store, tt.typ, tt.id, tt.params)
+		}
+	}
+}
+
+func TestParseUrlInvalid(t *testing.T) {
+	for _, url := range []string{
+		"https://example.com/track/song/17",
+		"https://beatport.com.example.com/track/song/17",
+		"https://www.beatport.com/de",
+		"https://www.beatport.com/de/genre/house/5",
+		"https://www.beatport.com/track/song",
+		"https://www.beatsource.com/fr/track/song",
+	} {
+		if _, err := ParseUrl(url); !errors.Is(err, ErrInvalidUrl) {
+			t.Errorf("ParseUrl(%q) = %v, want %v", url, err, ErrInvalidUrl)
+		}
+	}
+}
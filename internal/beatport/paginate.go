@@ -0,0 +1,137 @@
+package beatport
+
+import (
+	"context"
+	"iter"
+	"net/url"
+	"strconv"
+)
+
+// PageOptions controls how a paginated endpoint is walked.
+type PageOptions struct {
+	// PerPage is the page size requested from the API, 0 keeps the default
+	PerPage int
+	// MaxItems stops the iteration after that many items, 0 means no limit
+	MaxItems int
+}
+
+// PageFetcher fetches a single page of a paginated endpoint with the given
+// query parameters.
+type PageFetcher[T any] func(ctx context.Context, page int, params string) (*Paginated[T], error)
+
+// Paginate iterates over every item of a paginated endpoint, following the
+// pages until the last one. The next page is fetched while the items of
+// the current one are consumed. Iteration stops at the first error, which
+// is yielded with a zero item, including the cause of a cancelled ctx.
+func Paginate[T any](ctx context.Context, fetch PageFetcher[T], params string, opts PageOptions) iter.Seq2[T, error] {
+	params = withPerPage(params, opts.PerPage)
+
+	return func(yield func(T, error) bool) {
+		var zero T
+		ctx, cancel := context.WithCancel(ctx)
+		// Stops a prefetch nobody is waiting for anymore
+		defer cancel()
+
+		type result struct {
+			page *Paginated[T]
+			err  error
+		}
+		prefetch := func(page int) <-chan result {
+			ch := make(chan result, 1)
+			go func() {
+				res, err := fetch(ctx, page, params)
+				ch <- result{page: res, err: err}
+			}()
+			return ch
+		}
+
+		count := 0
+		next := prefetch(1)
+		for page := 1; ; page++ {
+			var res result
+			select {
+			case <-ctx.Done():
+				yield(zero, context.Cause(ctx))
+				return
+			case res = <-next:
+			}
+			if res.err != nil {
+				yield(zero, res.err)
+				return
+			}
+
+			more := res.page.Next != nil && len(res.page.Results) > 0
+			if opts.MaxItems > 0 && count+len(res.page.Results) >= opts.MaxItems {
+				more = false
+			}
+			if more {
+				next = prefetch(page + 1)
+			}
+
+			for _, item := range res.page.Results {
+				if ctx.Err() != nil {
+					yield(zero, context.Cause(ctx))
+					return
+				}
+				if !yield(item, nil) {
+					return
+				}
+				count++
+				if opts.MaxItems > 0 && count >= opts.MaxItems {
+					return
+				}
+			}
+			if !more {
+				return
+			}
+		}
+	}
+}
+
+// withPerPage sets the page size in a query string.
+func withPerPage(params string, perPage int) string {
+	if perPage <= 0 {
+		return params
+	}
+	values, err := url.ParseQuery(params)
+	if err != nil {
+		values = url.Values{}
+	}
+	values.Set("per_page", strconv.Itoa(perPage))
+	return values.Encode()
+}
+
+// ReleaseTracks iterates over all tracks of a release.
+func (b *Beatport) ReleaseTracks(ctx context.Context, id int64, params string, opts PageOptions) iter.Seq2[Track, error] {
+	return Paginate(ctx, func(ctx context.Context, page int, params string) (*Paginated[Track], error) {
+		return b.GetReleaseTracks(ctx, id, page, params)
+	}, params, opts)
+}
+
+// PlaylistItems iterates over all items of a playlist.
+func (b *Beatport) PlaylistItems(ctx context.Context, id int64, params string, opts PageOptions) iter.Seq2[PlaylistItem, error] {
+	return Paginate(ctx, func(ctx context.Context, page int, params string) (*Paginated[PlaylistItem], error) {
+		return b.GetPlaylistItems(ctx, id, page, params)
+	}, params, opts)
+}
+
+// ChartTracks iterates over all tracks of a chart.
+func (b *Beatport) ChartTracks(ctx context.Context, id int64, params string, opts PageOptions) iter.Seq2[Track, error] {
+	return Paginate(ctx, func(ctx context.Context, page int, params string) (*Paginated[Track], error) {
+		return b.GetChartTracks(ctx, id, page, params)
+	}, params, opts)
+}
+
+// ArtistTracks iterates over all tracks of an artist.
+func (b *Beatport) ArtistTracks(ctx context.Context, id int64, params string, opts PageOptions) iter.Seq2[Track, error] {
+	return Paginate(ctx, func(ctx context.Context, page int, params string) (*Paginated[Track], error) {
+		return b.GetArtistTracks(ctx, id, page, params)
+	}, params, opts)
+}
+
+// LabelReleases iterates over all releases of a label.
+func (b *Beatport) LabelReleases(ctx context.Context, id int64, params string, opts PageOptions) iter.Seq2[Release, error] {
+	return Paginate(ctx, func(ctx context.Context, page int, params string) (*Paginated[Release], error) {
+		return b.GetLabelReleases(ctx, id, page, params)
+	}, params, opts)
+}
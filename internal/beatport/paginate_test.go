@@ -0,0 +1,200 @@
+package beatport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// pageServer serves total numbered items in pages and records the queries
+// it was asked for
+type pageServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	queries []url.Values
+}
+
+func newPageServer(t *testing.T, total int, fail int) *pageServer {
+	s := &pageServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		s.mu.Lock()
+		s.queries = append(s.queries, query)
+		s.mu.Unlock()
+
+		page, _ := strconv.Atoi(query.Get("page"))
+		if page == fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		perPage := 10
+		if n, err := strconv.Atoi(query.Get("per_page")); err == nil {
+			perPage = n
+		}
+
+		response := Paginated[int]{Count: total, Page: strconv.Itoa(page), PerPage: perPage}
+		for i := (page - 1) * perPage; i < min(page*perPage, total); i++ {
+			response.Results = append(response.Results, i)
+		}
+		if page*perPage < total {
+			next := fmt.Sprintf("%s/?page=%d", s.URL, page+1)
+			response.Next = &next
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *pageServer) fetch(ctx context.Context, page int, params string) (*Paginated[int], error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/?page=%d&%s", s.URL, page, params), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, &ServerError{Code: res.StatusCode, Message: res.Status}
+	}
+	var response Paginated[int]
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+func (s *pageServer) pages() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var pages []string
+	for _, query := range s.queries {
+		pages = append(pages, query.Get("page"))
+	}
+	return pages
+}
+
+func TestPaginate(t *testing.T) {
+	tests := []struct {
+		name  string
+		total int
+		opts  PageOptions
+		want  int
+		pages int
+	}{
+		{name: "all pages", total: 25, want: 25, pages: 3},
+		{name: "exact last page", total: 20, want: 20, pages: 2},
+		{name: "empty", total: 0, want: 0, pages: 1},
+		{name: "per page", total: 25, opts: PageOptions{PerPage: 5}, want: 25, pages: 5},
+		{name: "max items", total: 25, opts: PageOptions{MaxItems: 12}, want: 12, pages: 2},
+		{name: "max items on a page boundary", total: 25, opts: PageOptions{MaxItems: 10}, want: 10, pages: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newPageServer(t, tt.total, 0)
+			var got []int
+			for item, err := range Paginate(context.Background(), s.fetch, "genre_id=1", tt.opts) {
+				if err != nil {
+					t.Fatalf("Paginate: %v", err)
+				}
+				got = append(got, item)
+			}
+			if len(got) != tt.want {
+				t.Errorf("got %d items, want %d", len(got), tt.want)
+			}
+			for i, item := range got {
+				if item != i {
+					t.Fatalf("item %d = %d, items out of order", i, item)
+				}
+			}
+			if pages := s.pages(); len(pages) != tt.pages {
+				t.Errorf("fetched pages %v, want %d", pages, tt.pages)
+			}
+			for _, query := range s.queries {
+				if query.Get("genre_id") != "1" {
+					t.Errorf("query %v lost the params", query)
+				}
+				if tt.opts.PerPage > 0 && query.Get("per_page") != strconv.Itoa(tt.opts.PerPage) {
+					t.Errorf("query %v, want per_page=%d", query, tt.opts.PerPage)
+				}
+			}
+		})
+	}
+}
+
+func TestPaginateStopsOnError(t *testing.T) {
+	s := newPageServer(t, 50, 2)
+	count := 0
+	var err error
+	for item, itemErr := range Paginate(context.Background(), s.fetch, "", PageOptions{}) {
+		if itemErr != nil {
+			if item != 0 {
+				t.Errorf("error yielded with item %d", item)
+			}
+			err = itemErr
+			continue
+		}
+		count++
+	}
+	var serverErr *ServerError
+	if !errors.As(err, &serverErr) || serverErr.Code != http.StatusInternalServerError {
+		t.Errorf("err = %v, want the failed page's error", err)
+	}
+	if count != 10 {
+		t.Errorf("got %d items before the error, want 10", count)
+	}
+}
+
+func TestPaginateCancel(t *testing.T) {
+	s := newPageServer(t, 50, 0)
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	stop := errors.New("stop")
+
+	count := 0
+	var err error
+	for _, itemErr := range Paginate(ctx, s.fetch, "", PageOptions{}) {
+		if itemErr != nil {
+			err = itemErr
+			break
+		}
+		count++
+		if count == 3 {
+			cancel(stop)
+		}
+	}
+	if !errors.Is(err, stop) {
+		t.Errorf("err = %v, want the cancel cause", err)
+	}
+	if count != 3 {
+		t.Errorf("got %d items, want 3", count)
+	}
+}
+
+func TestWithPerPage(t *testing.T) {
+	tests := []struct {
+		params  string
+		perPage int
+		want    string
+	}{
+		{params: "genre_id=1", perPage: 0, want: "genre_id=1"},
+		{params: "genre_id=1", perPage: 50, want: "genre_id=1&per_page=50"},
+		{params: "per_page=10", perPage: 100, want: "per_page=100"},
+		{params: "", perPage: 5, want: "per_page=5"},
+	}
+	for _, tt := range tests {
+		if got := withPerPage(tt.params, tt.perPage); got != tt.want {
+			t.Errorf("withPerPage(%q, %d) = %q, want %q", tt.params, tt.perPage, got, tt.want)
+		}
+	}
+}
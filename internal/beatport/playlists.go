@@ -1,6 +1,7 @@
 package beatport
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"strconv"
@@ -50,8 +51,9 @@ func (p *Playlist) DirectoryName(n NamingPreferences) string {
 	return SanitizePath(directoryName, n.Whitespace)
 }
 
-func (b *Beatport) GetPlaylist(id int64) (*Playlist, error) {
+func (b *Beatport) GetPlaylist(ctx context.Context, id int64) (*Playlist, error) {
 	res, err := b.fetch(
+		ctx,
 		"GET",
 		fmt.Sprintf("/catalog/playlists/%d/", id),
 		nil,
@@ -68,8 +70,9 @@ func (b *Beatport) GetPlaylist(id int64) (*Playlist, error) {
 	return response, nil
 }
 
-func (b *Beatport) GetPlaylistItems(id int64, page int, params string) (*Paginated[PlaylistItem], error) {
+func (b *Beatport) GetPlaylistItems(ctx context.Context, id int64, page int, params string) (*Paginated[PlaylistItem], error) {
 	res, err := b.fetch(
+		ctx,
 		"GET",
 		fmt.Sprintf("/catalog/playlists/%d/tracks/?page=%d&%s", id, page, params),
 		nil,
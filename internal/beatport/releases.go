@@ -1,6 +1,7 @@
 package beatport
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"strconv"
@@ -34,27 +35,18 @@ func (r *Release) StoreUrl() string {
 	return storeUrl(r.ID, "release", r.Slug, r.Store)
 }
 
-func (b *Beatport) GetRelease(id int64) (*Release, error) {
-	res, err := b.fetch(
-		"GET",
-		fmt.Sprintf("/catalog/releases/%d/", id),
-		nil,
-		"",
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
+func (b *Beatport) GetRelease(ctx context.Context, id int64) (*Release, error) {
 	response := &Release{}
-	if err = json.NewDecoder(res.Body).Decode(response); err != nil {
+	if err := b.getCached(ctx, "releases", id, fmt.Sprintf("/catalog/releases/%d/", id), response); err != nil {
 		return nil, err
 	}
 	response.Store = b.store
 	return response, nil
 }
 
-func (b *Beatport) GetReleaseTracks(id int64, page int, params string) (*Paginated[Track], error) {
+func (b *Beatport) GetReleaseTracks(ctx context.Context, id int64, page int, params string) (*Paginated[Track], error) {
 	res, err := b.fetch(
+		ctx,
 		"GET",
 		fmt.Sprintf("/catalog/releases/%d/tracks/?page=%d&%s", id, page, params),
 		nil,
@@ -1,20 +1,192 @@
 package beatport
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/unspok3n/beatportdl-ui/internal/validator"
+)
+
+type SearchType string
+
+const (
+	SearchTracks   SearchType = "tracks"
+	SearchReleases SearchType = "releases"
+	SearchArtists  SearchType = "artists"
+	SearchLabels   SearchType = "labels"
+	SearchCharts   SearchType = "charts"
+)
+
+var SearchTypes = []SearchType{
+	SearchTracks,
+	SearchReleases,
+	SearchArtists,
+	SearchLabels,
+	SearchCharts,
+}
+
+// SearchOrders lists the fields results can be sorted by. A leading "-"
+// sorts in descending order.
+var SearchOrders = []string{
+	"publish_date",
+	"release_date",
+	"name",
+	"bpm",
+	"genre",
+	"label",
+}
+
+const (
+	defaultSearchPerPage = 25
+	maxSearchPerPage     = 100
+	// defaultSearchOrder lists the newest results first
+	defaultSearchOrder = "-publish_date"
+
+	// SearchDateLayout is the format of release dates in search filters
+	SearchDateLayout = "2006-01-02"
 )
 
+// SearchOptions narrows down a catalog search. Zero values leave the
+// corresponding filter out.
+type SearchOptions struct {
+	Query string
+	// Type restricts the results to a single kind of entity, all kinds are
+	// returned when empty
+	Type       SearchType
+	GenreID    int64
+	SubgenreID int64
+	KeyID      int64
+	LabelID    int64
+	ArtistID   int64
+	MinBPM     int
+	MaxBPM     int
+	// ReleasedAfter and ReleasedBefore bound the publish date, both
+	// inclusive
+	ReleasedAfter  time.Time
+	ReleasedBefore time.Time
+	// OrderBy is one of SearchOrders, newest first by default
+	OrderBy string
+	// IncludeUnstreamable also returns results that are not available for
+	// streaming, some of which can still be downloaded. Only streamable
+	// results are returned by default.
+	IncludeUnstreamable bool
+	Page                int
+	PerPage             int
+}
+
+// Validate checks the options and fills in the default order, page and
+// page size.
+func (o *SearchOptions) Validate() error {
+	if o.Type != "" && !validator.PermittedValue(o.Type, SearchTypes...) {
+		return fmt.Errorf("invalid search type: %s", o.Type)
+	}
+	if o.OrderBy != "" && !validator.PermittedValue(strings.TrimPrefix(o.OrderBy, "-"), SearchOrders...) {
+		return fmt.Errorf("invalid order: %s", o.OrderBy)
+	}
+	if o.MinBPM < 0 || o.MaxBPM < 0 {
+		return fmt.Errorf("bpm must not be negative")
+	}
+	if o.MinBPM > 0 && o.MaxBPM > 0 && o.MinBPM > o.MaxBPM {
+		return fmt.Errorf("minimum bpm must not be greater than maximum bpm")
+	}
+	if !o.ReleasedAfter.IsZero() && !o.ReleasedBefore.IsZero() && o.ReleasedAfter.After(o.ReleasedBefore) {
+		return fmt.Errorf("release date range is empty")
+	}
+	if o.Page < 0 || o.PerPage < 0 {
+		return fmt.Errorf("page must not be negative")
+	}
+	if o.OrderBy == "" {
+		o.OrderBy = defaultSearchOrder
+	}
+	if o.Page == 0 {
+		o.Page = 1
+	}
+	if o.PerPage == 0 {
+		o.PerPage = defaultSearchPerPage
+	}
+	o.PerPage = min(o.PerPage, maxSearchPerPage)
+	return nil
+}
+
+// values encodes the options as query parameters of the search endpoint.
+// Ranges are sent as "from:to", with an open end left empty.
+func (o *SearchOptions) values() url.Values {
+	v := url.Values{}
+	v.Set("q", o.Query)
+	if !o.IncludeUnstreamable {
+		v.Set("is_available_for_streaming", "true")
+	}
+	v.Set("page", strconv.Itoa(o.Page))
+	v.Set("per_page", strconv.Itoa(o.PerPage))
+	if o.Type != "" {
+		v.Set("type", string(o.Type))
+	}
+	if o.OrderBy != "" {
+		v.Set("order_by", o.OrderBy)
+	}
+
+	ids := map[string]int64{
+		"genre_id":     o.GenreID,
+		"sub_genre_id": o.SubgenreID,
+		"key_id":       o.KeyID,
+		"label_id":     o.LabelID,
+		"artist_id":    o.ArtistID,
+	}
+	for name, id := range ids {
+		if id > 0 {
+			v.Set(name, strconv.FormatInt(id, 10))
+		}
+	}
+
+	if o.MinBPM > 0 || o.MaxBPM > 0 {
+		var from, to string
+		if o.MinBPM > 0 {
+			from = strconv.Itoa(o.MinBPM)
+		}
+		if o.MaxBPM > 0 {
+			to = strconv.Itoa(o.MaxBPM)
+		}
+		v.Set("bpm", from+":"+to)
+	}
+	if !o.ReleasedAfter.IsZero() || !o.ReleasedBefore.IsZero() {
+		var from, to string
+		if !o.ReleasedAfter.IsZero() {
+			from = o.ReleasedAfter.Format(SearchDateLayout)
+		}
+		if !o.ReleasedBefore.IsZero() {
+			to = o.ReleasedBefore.Format(SearchDateLayout)
+		}
+		v.Set("publish_date", from+":"+to)
+	}
+	return v
+}
+
 type SearchResults struct {
 	Tracks   []Track   `json:"tracks"`
 	Releases []Release `json:"releases"`
+	Artists  []Artist  `json:"artists"`
+	Labels   []Label   `json:"labels"`
+	Charts   []Chart   `json:"charts"`
+	Page     int       `json:"page"`
+	PerPage  int       `json:"per_page"`
+	// HasMore is set when any of the result lists filled the page, so the
+	// next page may hold more results
+	HasMore bool `json:"has_more"`
 }
 
-func (b *Beatport) Search(query string) (*SearchResults, error) {
+func (b *Beatport) Search(ctx context.Context, opts SearchOptions) (*SearchResults, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
 	res, err := b.fetch(
+		ctx,
 		"GET",
-		fmt.Sprintf("/catalog/search/?q=%s&order_by=-publish_date&is_available_for_streaming=true", url.QueryEscape(query)),
+		"/catalog/search/?"+opts.values().Encode(),
 		nil,
 		"",
 	)
@@ -26,5 +198,35 @@ func (b *Beatport) Search(query string) (*SearchResults, error) {
 	if err = json.NewDecoder(res.Body).Decode(response); err != nil {
 		return nil, err
 	}
+
+	for i := range response.Tracks {
+		response.Tracks[i].Store = b.store
+	}
+	for i := range response.Releases {
+		response.Releases[i].Store = b.store
+	}
+	for i := range response.Artists {
+		response.Artists[i].Store = b.store
+	}
+	for i := range response.Labels {
+		response.Labels[i].Store = b.store
+	}
+	for i := range response.Charts {
+		response.Charts[i].Store = b.store
+	}
+
+	response.Page = opts.Page
+	response.PerPage = opts.PerPage
+	for _, n := range []int{
+		len(response.Tracks),
+		len(response.Releases),
+		len(response.Artists),
+		len(response.Labels),
+		len(response.Charts),
+	} {
+		if n >= opts.PerPage {
+			response.HasMore = true
+		}
+	}
 	return response, nil
 }
@@ -0,0 +1,33 @@
+package beatport
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestSearchSetsStore(t *testing.T) {
+	b := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{
+			"tracks": [{"id": 1}], "releases": [{"id": 2}], "artists": [{"id": 3}],
+			"labels": [{"id": 4}], "charts": [{"id": 5}]
+		}`))
+	}))
+
+	results, err := b.Search(context.Background(), SearchOptions{Query: "test"})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	stores := map[string]Store{
+		"track":   results.Tracks[0].Store,
+		"release": results.Releases[0].Store,
+		"artist":  results.Artists[0].Store,
+		"label":   results.Labels[0].Store,
+		"chart":   results.Charts[0].Store,
+	}
+	for kind, store := range stores {
+		if store != StoreBeatport {
+			t.Errorf("%s store = %q, want %q", kind, store, StoreBeatport)
+		}
+	}
+}
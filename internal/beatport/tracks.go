@@ -1,6 +1,7 @@
 package beatport
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/url"
@@ -43,6 +44,15 @@ func (t *Track) StoreUrl() string {
 	return storeUrl(t.ID, "track", t.Slug, t.Store)
 }
 
+// Title returns the track name followed by its mix name in parentheses,
+// or only the name when the track has no mix name.
+func (t *Track) Title() string {
+	if t.MixName.String() == "" {
+		return t.Name.String()
+	}
+	return fmt.Sprintf("%s (%s)", t.Name.String(), t.MixName.String())
+}
+
 func (t *Track) GenreWithSubgenre(separator string) string {
 	if t.Subgenre != nil {
 		return fmt.Sprintf("%s %s %s", t.Genre.Name, separator, t.Subgenre.Name)
@@ -87,27 +97,18 @@ func (t *Track) Filename(n NamingPreferences) string {
 	return SanitizePath(fileName, n.Whitespace)
 }
 
-func (b *Beatport) GetTrack(id int64) (*Track, error) {
-	res, err := b.fetch(
-		"GET",
-		fmt.Sprintf("/catalog/tracks/%d/", id),
-		nil,
-		"",
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
+func (b *Beatport) GetTrack(ctx context.Context, id int64) (*Track, error) {
 	response := &Track{}
-	if err = json.NewDecoder(res.Body).Decode(response); err != nil {
+	if err := b.getCached(ctx, "tracks", id, fmt.Sprintf("/catalog/tracks/%d/", id), response); err != nil {
 		return nil, err
 	}
 	response.Store = b.store
 	return response, nil
 }
 
-func (b *Beatport) DownloadTrack(id int64, quality string) (*TrackDownload, error) {
+func (b *Beatport) DownloadTrack(ctx context.Context, id int64, quality string) (*TrackDownload, error) {
 	res, err := b.fetch(
+		ctx,
 		"GET",
 		fmt.Sprintf(
 			"/catalog/tracks/%d/download/?quality=%s",
@@ -128,8 +129,9 @@ func (b *Beatport) DownloadTrack(id int64, quality string) (*TrackDownload, erro
 	return response, nil
 }
 
-func (b *Beatport) StreamTrack(id int64) (*TrackStream, error) {
+func (b *Beatport) StreamTrack(ctx context.Context, id int64) (*TrackStream, error) {
 	res, err := b.fetch(
+		ctx,
 		"GET",
 		fmt.Sprintf(
 			"/catalog/tracks/%d/stream/",
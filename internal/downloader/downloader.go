@@ -0,0 +1,312 @@
+// Package downloader fetches files over HTTP into resumable .part files.
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/unspok3n/beatportdl-ui/internal/ratelimit"
+)
+
+const (
+	partSuffix    = ".part"
+	sidecarSuffix = ".part.json"
+
+	defaultMaxAttempts = 5
+)
+
+// retryDelay is the wait before the second attempt, growing with every
+// further one
+var retryDelay = 2 * time.Second
+
+var (
+	ErrSizeMismatch = errors.New("downloaded size does not match content length")
+
+	errRangeNotSatisfiable = errors.New("range not satisfiable")
+)
+
+// StatusError is returned when the server answers with an unexpected
+// status code.
+type StatusError struct {
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("download failed with status code: %d", e.Code)
+}
+
+// URLSource returns a download URL. It is called again whenever the
+// previous URL has expired.
+type URLSource func(ctx context.Context) (string, error)
+
+// ProgressFunc is called after every write with the number of bytes on
+// disk and the expected total size, which is 0 when unknown.
+type ProgressFunc func(written, total int64)
+
+// Sidecar describes a partial download so it can be resumed later.
+type Sidecar struct {
+	URL  string `json:"url"`
+	ETag string `json:"etag,omitempty"`
+	Size int64  `json:"size"`
+}
+
+type Downloader struct {
+	client      *http.Client
+	maxAttempts int
+	limiter     *ratelimit.Limiter
+}
+
+func New(client *http.Client) *Downloader {
+	if client == nil {
+		client = &http.Client{}
+	}
+	return &Downloader{
+		client:      client,
+		maxAttempts: defaultMaxAttempts,
+	}
+}
+
+// SetLimiter caps the download speed with a limiter counting bytes. A
+// limiter shared between downloaders caps their combined speed.
+func (d *Downloader) SetLimiter(limiter *ratelimit.Limiter) {
+	d.limiter = limiter
+}
+
+// PartPath returns the path of the partial file kept for path.
+func PartPath(path string) string {
+	return path + partSuffix
+}
+
+func sidecarPath(path string) string {
+	return path + sidecarSuffix
+}
+
+// Remove deletes the partial file and sidecar kept for path.
+func Remove(path string) error {
+	for _, p := range []string{PartPath(path), sidecarPath(path)} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func readSidecar(path string) *Sidecar {
+	data, err := os.ReadFile(sidecarPath(path))
+	if err != nil {
+		return nil
+	}
+	var s Sidecar
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil
+	}
+	return &s
+}
+
+func writeSidecar(path string, s *Sidecar) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	// The sidecar holds a signed URL, keep it private
+	return os.WriteFile(sidecarPath(path), data, 0600)
+}
+
+// Download fetches the file from source into path. Data is written to a
+// .part file next to path, and an interrupted download continues from the
+// bytes already on disk using a Range request. A URL that answers with
+// 403 or 410 is treated as expired and replaced with a fresh one from
+// source. The finished file is checked against the expected size before
+// it is renamed to path. Download returns the size of the file.
+func (d *Downloader) Download(ctx context.Context, path string, source URLSource, progress ProgressFunc) (int64, error) {
+	sidecar := readSidecar(path)
+	if sidecar == nil {
+		sidecar = &Sidecar{}
+		// Without a sidecar there is no way to tell what a leftover part belongs to
+		os.Remove(PartPath(path))
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= d.maxAttempts; attempt++ {
+		if sidecar.URL == "" {
+			url, err := source(ctx)
+			if err != nil {
+				return 0, err
+			}
+			sidecar.URL = url
+		}
+
+		size, err := d.attempt(ctx, path, sidecar, progress)
+		if err == nil {
+			if err := os.Rename(PartPath(path), path); err != nil {
+				return 0, fmt.Errorf("failed to move finished download: %w", err)
+			}
+			os.Remove(sidecarPath(path))
+			return size, nil
+		}
+		if ctx.Err() != nil {
+			return 0, context.Cause(ctx)
+		}
+
+		lastErr = err
+		var statusErr *StatusError
+		switch {
+		case errors.As(err, &statusErr) && (statusErr.Code == http.StatusForbidden || statusErr.Code == http.StatusGone):
+			// The signed URL expired, ask for a new one right away
+			sidecar.URL = ""
+			continue
+		case errors.As(err, &statusErr) && statusErr.Code < http.StatusInternalServerError:
+			return 0, err
+		case errors.Is(err, errRangeNotSatisfiable):
+			Remove(path)
+			sidecar = &Sidecar{URL: sidecar.URL}
+			continue
+		case errors.Is(err, ErrSizeMismatch):
+			Remove(path)
+			sidecar = &Sidecar{URL: sidecar.URL}
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, context.Cause(ctx)
+		case <-time.After(retryDelay * time.Duration(attempt)):
+		}
+	}
+
+	return 0, fmt.Errorf("download failed after %d attempts: %w", d.maxAttempts, lastErr)
+}
+
+func (d *Downloader) attempt(ctx context.Context, path string, sidecar *Sidecar, progress ProgressFunc) (int64, error) {
+	var offset int64
+	if info, err := os.Stat(PartPath(path)); err == nil {
+		offset = info.Size()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sidecar.URL, nil)
+	if err != nil {
+		return 0, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if sidecar.ETag != "" {
+			req.Header.Set("If-Range", sidecar.ETag)
+		}
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	fileFlags := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		start, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != offset {
+			return 0, fmt.Errorf("unexpected content range: %q", resp.Header.Get("Content-Range"))
+		}
+		if total > 0 {
+			sidecar.Size = total
+		}
+		fileFlags |= os.O_APPEND
+	case http.StatusOK:
+		// The range was ignored or the file changed, start over
+		offset = 0
+		sidecar.Size = max(resp.ContentLength, 0)
+		fileFlags |= os.O_TRUNC
+	case http.StatusRequestedRangeNotSatisfiable:
+		if sidecar.Size > 0 && offset == sidecar.Size {
+			return offset, nil
+		}
+		return 0, errRangeNotSatisfiable
+	default:
+		return 0, &StatusError{Code: resp.StatusCode}
+	}
+
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		sidecar.ETag = etag
+	}
+	if err := writeSidecar(path, sidecar); err != nil {
+		return 0, fmt.Errorf("failed to write sidecar: %w", err)
+	}
+
+	outFile, err := os.OpenFile(PartPath(path), fileFlags, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer outFile.Close()
+
+	written := offset
+	if progress != nil {
+		progress(written, sidecar.Size)
+	}
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, err := outFile.Write(buf[:n]); err != nil {
+				return 0, fmt.Errorf("failed to write file: %w", err)
+			}
+			written += int64(n)
+			if progress != nil {
+				progress(written, sidecar.Size)
+			}
+			if err := d.limiter.WaitN(ctx, n); err != nil {
+				return 0, err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err := outFile.Close(); err != nil {
+		return 0, fmt.Errorf("failed to write file: %w", err)
+	}
+	if sidecar.Size > 0 && written != sidecar.Size {
+		return 0, fmt.Errorf("%w: got %d bytes, expected %d", ErrSizeMismatch, written, sidecar.Size)
+	}
+
+	return written, nil
+}
+
+// parseContentRange parses a "bytes start-end/total" header. The total is
+// 0 when the server reports it as unknown.
+func parseContentRange(header string) (start, total int64, ok bool) {
+	spec, found := strings.CutPrefix(header, "bytes ")
+	if !found {
+		return 0, 0, false
+	}
+	rangePart, totalPart, found := strings.Cut(spec, "/")
+	if !found {
+		return 0, 0, false
+	}
+	startPart, _, found := strings.Cut(rangePart, "-")
+	if !found {
+		return 0, 0, false
+	}
+
+	start, err := strconv.ParseInt(startPart, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	if totalPart != "*" {
+		total, err = strconv.ParseInt(totalPart, 10, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+	}
+	return start, total, true
+}
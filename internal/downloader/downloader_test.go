@@ -0,0 +1,268 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+var content = bytes.Repeat([]byte("0123456789abcdef"), 4096)
+
+// serveContent answers like a file host, honouring Range and If-Range
+func serveContent(etag string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}
+}
+
+// recorder keeps the requests a test server received
+type recorder struct {
+	mu       sync.Mutex
+	requests []*http.Request
+}
+
+func (rec *recorder) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec.mu.Lock()
+		rec.requests = append(rec.requests, r.Clone(context.Background()))
+		rec.mu.Unlock()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// source returns the given URLs one after another and counts the calls
+func source(urls ...string) (URLSource, *int) {
+	calls := 0
+	return func(ctx context.Context) (string, error) {
+		url := urls[min(calls, len(urls)-1)]
+		calls++
+		return url, nil
+	}, &calls
+}
+
+// partial leaves the first n bytes of content behind as an interrupted
+// download of url
+func partial(t *testing.T, path string, n int, sidecar Sidecar) {
+	t.Helper()
+	if err := os.WriteFile(PartPath(path), content[:n], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeSidecar(path, &sidecar); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkDownloaded(t *testing.T, path string, size int64, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if size != int64(len(content)) {
+		t.Errorf("size = %d, want %d", size, len(content))
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("downloaded file differs from the content")
+	}
+	for _, leftover := range []string{PartPath(path), sidecarPath(path)} {
+		if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+			t.Errorf("%s was not removed", filepath.Base(leftover))
+		}
+	}
+}
+
+func TestDownloadResume(t *testing.T) {
+	tests := []struct {
+		name string
+		// etag is what the sidecar remembers, the server sends "v1"
+		etag      string
+		wantRange string
+		wantFull  bool
+	}{
+		{name: "same file", etag: `"v1"`, wantRange: "bytes=20000-"},
+		{name: "changed file", etag: `"v0"`, wantRange: "bytes=20000-", wantFull: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &recorder{}
+			srv := httptest.NewServer(rec.wrap(serveContent(`"v1"`)))
+			defer srv.Close()
+
+			path := filepath.Join(t.TempDir(), "track.temp")
+			partial(t, path, 20000, Sidecar{URL: srv.URL, ETag: tt.etag, Size: int64(len(content))})
+
+			var first, last int64 = -1, 0
+			progress := func(written, total int64) {
+				if first < 0 {
+					first = written
+				}
+				last = written
+			}
+			src, calls := source(srv.URL)
+			size, err := New(nil).Download(context.Background(), path, src, progress)
+			checkDownloaded(t, path, size, err)
+
+			if *calls != 0 {
+				t.Errorf("source called %d times, the sidecar URL should be reused", *calls)
+			}
+			if len(rec.requests) != 1 {
+				t.Fatalf("%d requests, want 1", len(rec.requests))
+			}
+			req := rec.requests[0]
+			if got := req.Header.Get("Range"); got != tt.wantRange {
+				t.Errorf("Range = %q, want %q", got, tt.wantRange)
+			}
+			if got := req.Header.Get("If-Range"); got != tt.etag {
+				t.Errorf("If-Range = %q, want %q", got, tt.etag)
+			}
+			wantFirst := int64(20000)
+			if tt.wantFull {
+				wantFirst = 0
+			}
+			if first != wantFirst || last != int64(len(content)) {
+				t.Errorf("progress went from %d to %d, want %d to %d", first, last, wantFirst, len(content))
+			}
+		})
+	}
+}
+
+func TestDownloadStartsOverOnBadPartial(t *testing.T) {
+	defer func(d time.Duration) { retryDelay = d }(retryDelay)
+	retryDelay = time.Millisecond
+
+	tests := []struct {
+		name    string
+		handler func(attempt int) http.HandlerFunc
+		prepare func(t *testing.T, path, url string)
+	}{
+		{
+			// The part is longer than the file, so the range starts past its end
+			name: "range not satisfiable",
+			handler: func(int) http.HandlerFunc {
+				return serveContent(`"v1"`)
+			},
+			prepare: func(t *testing.T, path, url string) {
+				if err := os.WriteFile(PartPath(path), append(content, "junk"...), 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := writeSidecar(path, &Sidecar{URL: url, ETag: `"v1"`}); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "size mismatch",
+			handler: func(attempt int) http.HandlerFunc {
+				if attempt > 1 {
+					return serveContent(`"v1"`)
+				}
+				// Claims a larger file than it sends
+				return func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(content)-1, len(content)+100))
+					w.WriteHeader(http.StatusPartialContent)
+					w.Write(content)
+				}
+			},
+			prepare: func(*testing.T, string, string) {},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			attempt := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				attempt++
+				n := attempt
+				mu.Unlock()
+				tt.handler(n)(w, r)
+			}))
+			defer srv.Close()
+
+			path := filepath.Join(t.TempDir(), "track.temp")
+			tt.prepare(t, path, srv.URL)
+
+			src, _ := source(srv.URL)
+			size, err := New(nil).Download(context.Background(), path, src, nil)
+			checkDownloaded(t, path, size, err)
+			if attempt != 2 {
+				t.Errorf("%d requests, want 2", attempt)
+			}
+		})
+	}
+}
+
+func TestDownloadStatus(t *testing.T) {
+	tests := []struct {
+		name      string
+		code      int
+		wantCalls int
+		wantErr   int
+	}{
+		{name: "forbidden refreshes the URL", code: http.StatusForbidden, wantCalls: 2},
+		{name: "gone refreshes the URL", code: http.StatusGone, wantCalls: 2},
+		{name: "not found is final", code: http.StatusNotFound, wantCalls: 1, wantErr: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.HandleFunc("/expired", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.code)
+			})
+			mux.Handle("/fresh", serveContent(`"v1"`))
+			srv := httptest.NewServer(mux)
+			defer srv.Close()
+
+			path := filepath.Join(t.TempDir(), "track.temp")
+			src, calls := source(srv.URL+"/expired", srv.URL+"/fresh")
+			size, err := New(nil).Download(context.Background(), path, src, nil)
+
+			if *calls != tt.wantCalls {
+				t.Errorf("source called %d times, want %d", *calls, tt.wantCalls)
+			}
+			if tt.wantErr == 0 {
+				checkDownloaded(t, path, size, err)
+				return
+			}
+			var statusErr *StatusError
+			if !errors.As(err, &statusErr) || statusErr.Code != tt.wantErr {
+				t.Errorf("err = %v, want status %d", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		header       string
+		start, total int64
+		ok           bool
+	}{
+		{header: "bytes 100-199/200", start: 100, total: 200, ok: true},
+		{header: "bytes 0-99/*", start: 0, total: 0, ok: true},
+		{header: "bytes */200", ok: false},
+		{header: "items 0-1/2", ok: false},
+		{header: "", ok: false},
+	}
+	for _, tt := range tests {
+		start, total, ok := parseContentRange(tt.header)
+		if start != tt.start || total != tt.total || ok != tt.ok {
+			t.Errorf("parseContentRange(%q) = %d, %d, %v; want %d, %d, %v", tt.header, start, total, ok, tt.start, tt.total, tt.ok)
+		}
+	}
+}
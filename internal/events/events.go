@@ -0,0 +1,140 @@
+// Package events fans out job events to subscribers and keeps a short
+// history so reconnecting clients can catch up on what they missed.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	JobCreated  = "job.created"
+	JobStatus   = "job.status"
+	JobProgress = "job.progress"
+	JobError    = "job.error"
+	JobDeleted  = "job.deleted"
+
+	// Config events carry no job ID
+	ConfigReloaded = "config.reloaded"
+	ConfigRejected = "config.rejected"
+
+	// subscriberBuffer is the number of events a subscriber can fall
+	// behind before it is dropped
+	subscriberBuffer = 256
+)
+
+type Event struct {
+	ID    uint64      `json:"id"`
+	Type  string      `json:"type"`
+	JobID string      `json:"job_id,omitempty"`
+	Time  time.Time   `json:"time"`
+	Data  interface{} `json:"data,omitempty"`
+}
+
+// Broker publishes events to every subscriber. Subscribers that do not
+// keep up are dropped instead of slowing down the publisher; they are
+// expected to reconnect with the ID of the last event they received.
+//
+// The history only keeps the latest progress event of each job, so the
+// frequent progress updates of running downloads don't push the status
+// changes clients care about out of it.
+type Broker struct {
+	nextID  uint64
+	history []Event
+	// evicted is the ID of the latest event that fell out of the history
+	// for lack of room. Superseded progress events don't count, replaying
+	// the newer one is enough.
+	evicted     uint64
+	limit       int
+	subscribers map[chan Event]struct{}
+	mutex       sync.Mutex
+}
+
+// NewBroker returns a broker remembering the last limit events.
+func NewBroker(limit int) *Broker {
+	return &Broker{
+		nextID:      1,
+		limit:       limit,
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+// Publish assigns the next ID to an event and sends it to all subscribers.
+func (b *Broker) Publish(eventType, jobID string, data interface{}) Event {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	event := Event{
+		ID:    b.nextID,
+		Type:  eventType,
+		JobID: jobID,
+		Time:  time.Now(),
+		Data:  data,
+	}
+	b.nextID++
+
+	if eventType == JobProgress {
+		b.removeProgress(jobID)
+	}
+	b.history = append(b.history, event)
+	if len(b.history) > b.limit {
+		drop := len(b.history) - b.limit
+		b.evicted = b.history[drop-1].ID
+		b.history = b.history[drop:]
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+	return event
+}
+
+// Subscribe returns the events published after lastID along with a channel
+// receiving every later event. complete is false when some of the missed
+// events are no longer in the history. The channel is closed when the
+// subscriber falls behind or unsubscribe is called.
+func (b *Broker) Subscribe(lastID uint64) (missed []Event, complete bool, ch <-chan Event, unsubscribe func()) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	complete = true
+	// An ID from before a restart cannot be resumed
+	if lastID > 0 && lastID < b.nextID {
+		for _, event := range b.history {
+			if event.ID > lastID {
+				missed = append(missed, event)
+			}
+		}
+		complete = lastID >= b.evicted
+	} else if lastID >= b.nextID {
+		complete = false
+	}
+
+	sub := make(chan Event, subscriberBuffer)
+	b.subscribers[sub] = struct{}{}
+
+	return missed, complete, sub, func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		if _, ok := b.subscribers[sub]; ok {
+			delete(b.subscribers, sub)
+			close(sub)
+		}
+	}
+}
+
+// removeProgress removes the progress event of a job from the history. The
+// caller must hold the lock.
+func (b *Broker) removeProgress(jobID string) {
+	for i := len(b.history) - 1; i >= 0; i-- {
+		if b.history[i].Type == JobProgress && b.history[i].JobID == jobID {
+			b.history = append(b.history[:i], b.history[i+1:]...)
+			return
+		}
+	}
+}
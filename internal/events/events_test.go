@@ -0,0 +1,68 @@
+package events
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestProgressDoesNotEvictStatus(t *testing.T) {
+	b := NewBroker(10)
+	start := b.Publish(JobDeleted, "x", nil)
+	b.Publish(JobCreated, "a", nil)
+	for i := 0; i < 100; i++ {
+		for _, id := range []string{"a", "b", "c"} {
+			b.Publish(JobProgress, id, i)
+		}
+	}
+	status := b.Publish(JobStatus, "a", "completed")
+
+	missed, complete, _, unsubscribe := b.Subscribe(start.ID)
+	defer unsubscribe()
+	if !complete {
+		t.Error("replay reported incomplete, only superseded progress was dropped")
+	}
+	var got []string
+	for _, event := range missed {
+		got = append(got, fmt.Sprintf("%s %s %v", event.Type, event.JobID, event.Data))
+	}
+	want := []string{
+		"job.created a <nil>",
+		"job.progress a 99",
+		"job.progress b 99",
+		"job.progress c 99",
+		"job.status a completed",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("replayed %q, want %q", got, want)
+	}
+	if missed[len(missed)-1].ID != status.ID {
+		t.Errorf("last replayed ID = %d, want %d", missed[len(missed)-1].ID, status.ID)
+	}
+}
+
+func TestReplayIncompleteAfterEviction(t *testing.T) {
+	b := NewBroker(3)
+	first := b.Publish(JobCreated, "a", nil)
+	for _, id := range []string{"b", "c", "d", "e"} {
+		b.Publish(JobCreated, id, nil)
+	}
+
+	tests := []struct {
+		lastID   uint64
+		missed   int
+		complete bool
+	}{
+		{lastID: first.ID + 1, missed: 3, complete: true},
+		{lastID: first.ID, missed: 3, complete: false},
+		{lastID: first.ID + 4, missed: 0, complete: true},
+		// An ID from before a restart
+		{lastID: first.ID + 10, missed: 0, complete: false},
+	}
+	for _, tt := range tests {
+		missed, complete, _, unsubscribe := b.Subscribe(tt.lastID)
+		unsubscribe()
+		if len(missed) != tt.missed || complete != tt.complete {
+			t.Errorf("Subscribe(%d) = %d events, complete %v; want %d, %v", tt.lastID, len(missed), complete, tt.missed, tt.complete)
+		}
+	}
+}
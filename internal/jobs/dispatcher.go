@@ -0,0 +1,130 @@
+package jobs
+
+import (
+	"sync"
+)
+
+// Dispatcher runs queued jobs on a pool of workers. The pool can be
+// resized at any time: extra workers are started right away, and surplus
+// workers exit once they finish the job they are working on.
+type Dispatcher struct {
+	process func(id string)
+	queue   []string
+	queued  map[string]bool
+	size    int
+	running int
+	closed  bool
+	mutex   sync.Mutex
+	cond    *sync.Cond
+}
+
+func NewDispatcher(size int, process func(id string)) *Dispatcher {
+	d := &Dispatcher{
+		process: process,
+		queued:  make(map[string]bool),
+	}
+	d.cond = sync.NewCond(&d.mutex)
+	d.Resize(size)
+	return d
+}
+
+// Enqueue adds a job to the end of the queue. It reports false if the job
+// is already waiting in the queue.
+func (d *Dispatcher) Enqueue(id string) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.closed || d.queued[id] {
+		return false
+	}
+	d.queue = append(d.queue, id)
+	d.queued[id] = true
+	d.cond.Signal()
+	return true
+}
+
+// Prioritize moves a queued job to the front of the queue. It reports
+// false if the job is not waiting in the queue.
+func (d *Dispatcher) Prioritize(id string) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if !d.queued[id] {
+		return false
+	}
+	for i, queued := range d.queue {
+		if queued == id {
+			copy(d.queue[1:i+1], d.queue[:i])
+			d.queue[0] = id
+			break
+		}
+	}
+	return true
+}
+
+// Resize changes the number of workers.
+func (d *Dispatcher) Resize(size int) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.size = max(size, 1)
+	for d.running < d.size {
+		d.running++
+		go d.work()
+	}
+	// Wake idle workers so the surplus ones can exit
+	d.cond.Broadcast()
+}
+
+// Size returns the configured number of workers.
+func (d *Dispatcher) Size() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.size
+}
+
+// Pending returns the number of jobs waiting for a worker.
+func (d *Dispatcher) Pending() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return len(d.queue)
+}
+
+// Close stops all workers once they finish their current job. Jobs left in
+// the queue are not processed.
+func (d *Dispatcher) Close() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.closed = true
+	d.cond.Broadcast()
+}
+
+func (d *Dispatcher) next() (string, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for {
+		if d.closed || d.running > d.size {
+			d.running--
+			return "", false
+		}
+		if len(d.queue) > 0 {
+			id := d.queue[0]
+			d.queue = d.queue[1:]
+			delete(d.queued, id)
+			return id, true
+		}
+		d.cond.Wait()
+	}
+}
+
+func (d *Dispatcher) work() {
+	for {
+		id, ok := d.next()
+		if !ok {
+			return
+		}
+		d.process(id)
+	}
+}
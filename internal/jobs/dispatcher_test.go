@@ -0,0 +1,146 @@
+package jobs
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingPool processes jobs until release is sent to, and reports every
+// job it starts
+type blockingPool struct {
+	started chan string
+	release chan struct{}
+	running atomic.Int32
+	peak    atomic.Int32
+}
+
+func newBlockingPool() *blockingPool {
+	return &blockingPool{
+		started: make(chan string, 100),
+		release: make(chan struct{}),
+	}
+}
+
+func (p *blockingPool) process(id string) {
+	n := p.running.Add(1)
+	for {
+		peak := p.peak.Load()
+		if n <= peak || p.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	p.started <- id
+	<-p.release
+	p.running.Add(-1)
+}
+
+// expectStarted waits for n jobs to start and returns their IDs
+func (p *blockingPool) expectStarted(t *testing.T, n int) []string {
+	t.Helper()
+	var ids []string
+	for i := 0; i < n; i++ {
+		select {
+		case id := <-p.started:
+			ids = append(ids, id)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%d of %d jobs started", i, n)
+		}
+	}
+	return ids
+}
+
+// expectIdle checks that no further job starts
+func (p *blockingPool) expectIdle(t *testing.T) {
+	t.Helper()
+	select {
+	case id := <-p.started:
+		t.Fatalf("job %s started, the pool should be full", id)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestDispatcherResize(t *testing.T) {
+	p := newBlockingPool()
+	d := NewDispatcher(2, p.process)
+	defer d.Close()
+
+	for _, id := range []string{"a", "b", "c", "d", "e", "f"} {
+		d.Enqueue(id)
+	}
+	p.expectStarted(t, 2)
+	p.expectIdle(t)
+	if d.Pending() != 4 {
+		t.Errorf("pending = %d, want 4", d.Pending())
+	}
+
+	// Growing starts workers for the queued jobs right away
+	d.Resize(4)
+	p.expectStarted(t, 2)
+	p.expectIdle(t)
+	if d.Pending() != 2 || d.Size() != 4 {
+		t.Errorf("pending = %d, size = %d; want 2 and 4", d.Pending(), d.Size())
+	}
+
+	// Shrinking lets the running jobs finish and leaves a single worker
+	d.Resize(1)
+	for i := 0; i < 4; i++ {
+		p.release <- struct{}{}
+	}
+	p.expectStarted(t, 1)
+	p.expectIdle(t)
+	if n := p.running.Load(); n != 1 {
+		t.Errorf("%d jobs running after shrinking, want 1", n)
+	}
+	p.release <- struct{}{}
+	p.expectStarted(t, 1)
+	p.release <- struct{}{}
+
+	if peak := p.peak.Load(); peak != 4 {
+		t.Errorf("peak concurrency = %d, want 4", peak)
+	}
+}
+
+func TestDispatcherQueue(t *testing.T) {
+	p := newBlockingPool()
+	d := NewDispatcher(1, p.process)
+	defer d.Close()
+
+	d.Enqueue("a")
+	p.expectStarted(t, 1)
+	for _, id := range []string{"b", "c", "d"} {
+		if !d.Enqueue(id) {
+			t.Errorf("Enqueue(%s) = false", id)
+		}
+	}
+	if d.Enqueue("c") {
+		t.Error("Enqueue accepted a job that is already queued")
+	}
+	if !d.Prioritize("d") || d.Prioritize("a") {
+		t.Error("Prioritize should only move queued jobs")
+	}
+
+	var order []string
+	for i := 0; i < 3; i++ {
+		p.release <- struct{}{}
+		order = append(order, p.expectStarted(t, 1)...)
+	}
+	if want := []string{"d", "b", "c"}; len(order) != 3 || order[0] != want[0] || order[1] != want[1] || order[2] != want[2] {
+		t.Errorf("processed %v, want %v", order, want)
+	}
+
+	d.Close()
+	p.release <- struct{}{}
+	if d.Enqueue("e") {
+		t.Error("Enqueue accepted a job after Close")
+	}
+	p.expectIdle(t)
+}
+
+func TestDispatcherMinimumSize(t *testing.T) {
+	d := NewDispatcher(0, func(string) {})
+	defer d.Close()
+	if d.Size() != 1 {
+		t.Errorf("size = %d, want at least 1", d.Size())
+	}
+}
@@ -0,0 +1,105 @@
+// Package jobs keeps track of download jobs and persists them to disk so
+// queued and running downloads survive a server restart.
+package jobs
+
+import (
+	"time"
+)
+
+type Status string
+
+const (
+	StatusPending     Status = "pending"
+	StatusDownloading Status = "downloading"
+	StatusCompleted   Status = "completed"
+	StatusFailed      Status = "failed"
+	StatusPaused      Status = "paused"
+	StatusCancelled   Status = "cancelled"
+)
+
+var Statuses = []Status{
+	StatusPending,
+	StatusDownloading,
+	StatusCompleted,
+	StatusFailed,
+	StatusPaused,
+	StatusCancelled,
+}
+
+type Kind string
+
+const (
+	KindTrack      Kind = "track"
+	KindCollection Kind = "collection"
+)
+
+// Job is a download tracked by the server. A track job downloads a single
+// track, a collection job (release, playlist, chart, label or artist)
+// expands into child track jobs.
+type Job struct {
+	ID        string                 `json:"id"`
+	Kind      Kind                   `json:"kind,omitempty"`
+	TrackURL  string                 `json:"track_url"`
+	Status    Status                 `json:"status"`
+	Quality   string                 `json:"quality,omitempty"`
+	Metadata  map[string]interface{} `json:"metadata,omitempty"`
+	ParentID  string                 `json:"parent_id,omitempty"`
+	Directory string                 `json:"directory,omitempty"`
+	// TempPath is where the download is kept until it is moved into place,
+	// fixed when the job first starts
+	TempPath    string              `json:"temp_path,omitempty"`
+	Children    []string            `json:"children,omitempty"`
+	Expanded    bool                `json:"expanded,omitempty"`
+	Collection  *CollectionProgress `json:"collection,omitempty"`
+	Attempts    int                 `json:"attempts,omitempty"`
+	NextRetryAt *time.Time          `json:"next_retry_at,omitempty"`
+	CreatedAt   time.Time           `json:"created_at"`
+	UpdatedAt   time.Time           `json:"updated_at"`
+}
+
+// CollectionProgress is the aggregate state of a collection's child jobs.
+type CollectionProgress struct {
+	Total     int `json:"total"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+	Cancelled int `json:"cancelled"`
+	Percent   int `json:"percent"`
+}
+
+// IsCollection reports whether the job expands into child track jobs.
+func (j *Job) IsCollection() bool {
+	return j.Kind == KindCollection
+}
+
+// Unfinished reports whether the job still has work left to do.
+func (j *Job) Unfinished() bool {
+	return j.Status == StatusPending || j.Status == StatusDownloading
+}
+
+// Finished reports whether the job reached a final state: completed,
+// failed or cancelled.
+func (j *Job) Finished() bool {
+	return j.Status == StatusCompleted || j.Status == StatusFailed || j.Status == StatusCancelled
+}
+
+func (j *Job) clone() *Job {
+	c := *j
+	if j.Metadata != nil {
+		c.Metadata = make(map[string]interface{}, len(j.Metadata))
+		for key, value := range j.Metadata {
+			c.Metadata[key] = value
+		}
+	}
+	if j.Children != nil {
+		c.Children = append([]string(nil), j.Children...)
+	}
+	if j.Collection != nil {
+		collection := *j.Collection
+		c.Collection = &collection
+	}
+	if j.NextRetryAt != nil {
+		next := *j.NextRetryAt
+		c.NextRetryAt = &next
+	}
+	return &c
+}
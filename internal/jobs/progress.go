@@ -0,0 +1,85 @@
+package jobs
+
+import (
+	"time"
+)
+
+// speedSmoothing weighs the latest speed sample against the running
+// average, so short stalls don't make the ETA jump around
+const speedSmoothing = 0.3
+
+// Progress is the transfer state of a running download.
+type Progress struct {
+	Bytes   int64 `json:"bytes"`
+	Total   int64 `json:"total,omitempty"`
+	Percent int   `json:"percent"`
+	// Speed is in bytes per second
+	Speed int64 `json:"speed"`
+	// ETA is the estimated number of seconds left, omitted when unknown
+	ETA int64 `json:"eta,omitempty"`
+}
+
+// Meter turns byte counts reported during a download into Progress.
+type Meter struct {
+	interval  time.Duration
+	lastTime  time.Time
+	lastBytes int64
+	speed     float64
+	progress  Progress
+}
+
+// NewMeter returns a meter that samples the speed at most once per
+// interval.
+func NewMeter(interval time.Duration) *Meter {
+	return &Meter{interval: interval}
+}
+
+// Update records the bytes written so far and the expected total, which is
+// 0 when unknown. It reports whether a new sample was taken, which happens
+// at most once per interval and always when the download is complete.
+func (m *Meter) Update(written, total int64) (Progress, bool) {
+	now := time.Now()
+	if m.lastTime.IsZero() || written < m.lastBytes {
+		// First report, or the download started over
+		m.lastTime = now
+		m.lastBytes = written
+		m.speed = 0
+		m.progress = Progress{Bytes: written, Total: total, Percent: percent(written, total)}
+		return m.progress, true
+	}
+
+	elapsed := now.Sub(m.lastTime)
+	finished := total > 0 && written >= total
+	if elapsed < m.interval && !finished {
+		return m.progress, false
+	}
+
+	if elapsed > 0 {
+		sample := float64(written-m.lastBytes) / elapsed.Seconds()
+		if m.speed == 0 {
+			m.speed = sample
+		} else {
+			m.speed = speedSmoothing*sample + (1-speedSmoothing)*m.speed
+		}
+	}
+	m.lastTime = now
+	m.lastBytes = written
+
+	m.progress = Progress{
+		Bytes:   written,
+		Total:   total,
+		Percent: percent(written, total),
+		Speed:   int64(m.speed),
+	}
+	if total > 0 && m.speed > 0 {
+		m.progress.ETA = int64(float64(total-written) / m.speed)
+	}
+	return m.progress, true
+}
+
+func percent(written, total int64) int {
+	if total <= 0 {
+		return 0
+	}
+	return int(written * 100 / total)
+}
@@ -0,0 +1,383 @@
+package jobs
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrJobNotFound = errors.New("job not found")
+)
+
+// compactMinLines is the shortest journal compacted while the store is in
+// use. Longer journals are compacted once they hold more than twice as
+// many lines as there are jobs.
+var compactMinLines = 1000
+
+// Store is a file-backed job store. Every change is appended to a journal
+// file as a single JSON line, and the journal is compacted to one line per
+// job whenever the store is opened or pruned, or the journal grows too long.
+type Store struct {
+	path string
+	file *os.File
+	// lines is the number of lines in the journal
+	lines    int
+	jobs     map[string]*Job
+	onChange func(previous, current *Job)
+	mutex    sync.RWMutex
+}
+
+type journalEntry struct {
+	Job     *Job   `json:"job,omitempty"`
+	Deleted string `json:"deleted,omitempty"`
+}
+
+// Open loads the journal at path, creating it if it does not exist.
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path: path,
+		jobs: make(map[string]*Job),
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// OnChange registers fn to be called after every change to a job with
+// copies of the job before and after the change. previous is nil for new
+// jobs and current is nil for deleted ones. fn is called while the store
+// is locked, so changes are seen in order, and must not use the store.
+func (s *Store) OnChange(fn func(previous, current *Job)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.onChange = fn
+}
+
+// notify calls the change handler. The caller must hold the write lock.
+func (s *Store) notify(previous, current *Job) {
+	if s.onChange == nil {
+		return
+	}
+	if previous != nil {
+		previous = previous.clone()
+	}
+	if current != nil {
+		current = current.clone()
+	}
+	s.onChange(previous, current)
+}
+
+func (s *Store) load() error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to open job store: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// A crash can leave a partially written last line behind
+			continue
+		}
+		switch {
+		case entry.Deleted != "":
+			delete(s.jobs, entry.Deleted)
+		case entry.Job != nil && entry.Job.ID != "":
+			s.jobs[entry.Job.ID] = entry.Job
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read job store: %w", err)
+	}
+
+	return nil
+}
+
+// compact rewrites the journal with the current state of every job and
+// reopens it for appending. The caller must hold the write lock or have
+// exclusive access to the store.
+func (s *Store) compact() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create job store: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(w)
+	for _, job := range s.sorted() {
+		if err := encoder.Encode(journalEntry{Job: job}); err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to encode job: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write job store: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write job store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write job store: %w", err)
+	}
+
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+	renameErr := os.Rename(tmp.Name(), s.path)
+	if renameErr == nil {
+		s.lines = len(s.jobs)
+	}
+
+	// On failure the old journal is kept and appended to
+	s.file, err = os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open job store: %w", err)
+	}
+	if renameErr != nil {
+		return fmt.Errorf("failed to replace job store: %w", renameErr)
+	}
+
+	return nil
+}
+
+// compactIfLong compacts the journal once it has grown well past the
+// number of jobs. A failed compaction is tried again on the next change,
+// the journal itself stays valid. The caller must hold the write lock.
+func (s *Store) compactIfLong() {
+	if s.lines >= compactMinLines && s.lines > 2*len(s.jobs) {
+		s.compact()
+	}
+}
+
+func (s *Store) append(entry journalEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode job: %w", err)
+	}
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write job store: %w", err)
+	}
+	s.lines++
+	return nil
+}
+
+// sorted returns the jobs ordered by creation time. The caller must hold
+// the lock.
+func (s *Store) sorted() []*Job {
+	list := make([]*Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		list = append(list, job)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].ID < list[j].ID
+		}
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	return list
+}
+
+// Put inserts or replaces a job and records it in the journal.
+func (s *Store) Put(job *Job) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored := job.clone()
+	now := time.Now().UTC()
+	if stored.CreatedAt.IsZero() {
+		stored.CreatedAt = now
+	}
+	stored.UpdatedAt = now
+
+	if err := s.append(journalEntry{Job: stored}); err != nil {
+		return err
+	}
+	previous := s.jobs[stored.ID]
+	s.jobs[stored.ID] = stored
+	s.notify(previous, stored)
+	s.compactIfLong()
+	return nil
+}
+
+// Update applies fn to the stored job and records the result in the
+// journal. The job passed to fn is a copy; it only replaces the stored job
+// once the journal write succeeds.
+func (s *Store) Update(id string, fn func(job *Job)) (*Job, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	current, ok := s.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+
+	updated := current.clone()
+	fn(updated)
+	updated.ID = id
+	updated.UpdatedAt = time.Now().UTC()
+
+	if err := s.append(journalEntry{Job: updated}); err != nil {
+		return nil, err
+	}
+	s.jobs[id] = updated
+	s.notify(current, updated)
+	s.compactIfLong()
+	return updated.clone(), nil
+}
+
+// Get returns a copy of the job with the given ID.
+func (s *Store) Get(id string) (*Job, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, false
+	}
+	return job.clone(), true
+}
+
+// List returns copies of all jobs ordered by creation time.
+func (s *Store) List() []*Job {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	list := s.sorted()
+	for i := range list {
+		list[i] = list[i].clone()
+	}
+	return list
+}
+
+// Unfinished returns copies of all jobs that are still pending or were
+// downloading, ordered by creation time.
+func (s *Store) Unfinished() []*Job {
+	var list []*Job
+	for _, job := range s.List() {
+		if job.Unfinished() {
+			list = append(list, job)
+		}
+	}
+	return list
+}
+
+// Delete removes a job from the store.
+func (s *Store) Delete(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return ErrJobNotFound
+	}
+	if err := s.append(journalEntry{Deleted: id}); err != nil {
+		return err
+	}
+	delete(s.jobs, id)
+	s.notify(job, nil)
+	s.compactIfLong()
+	return nil
+}
+
+// Prune removes finished jobs, whether completed, failed or cancelled,
+// that last changed more than maxAge ago. A collection is removed together
+// with its children once all of them have finished, and children are
+// never removed on their own while their collection is still around. The
+// journal is compacted if anything was removed. It returns the number of
+// removed jobs.
+func (s *Store) Prune(maxAge time.Duration) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	cutoff := time.Now().Add(-maxAge)
+	var pruned []*Job
+	for _, job := range s.sorted() {
+		if _, ok := s.jobs[job.ParentID]; ok {
+			// Removed along with its collection
+			continue
+		}
+		if !job.Finished() || !job.UpdatedAt.Before(cutoff) {
+			continue
+		}
+		children, finished := s.children(job.ID)
+		if !finished {
+			continue
+		}
+		pruned = append(append(pruned, children...), job)
+	}
+	if len(pruned) == 0 {
+		return 0, nil
+	}
+
+	// Each removal is journaled first, like Delete, so an error leaves
+	// memory and disk in agreement
+	removed := 0
+	for _, job := range pruned {
+		if err := s.append(journalEntry{Deleted: job.ID}); err != nil {
+			return removed, err
+		}
+		delete(s.jobs, job.ID)
+		s.notify(job, nil)
+		removed++
+	}
+
+	if err := s.compact(); err != nil {
+		return removed, err
+	}
+	return removed, nil
+}
+
+// children returns the jobs whose parent is id, and whether all of them
+// have finished. The caller must hold the lock.
+func (s *Store) children(id string) ([]*Job, bool) {
+	var children []*Job
+	finished := true
+	for _, job := range s.jobs {
+		if job.ParentID != id {
+			continue
+		}
+		children = append(children, job)
+		finished = finished && job.Finished()
+	}
+	return children, finished
+}
+
+// Close closes the journal file.
+func (s *Store) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
@@ -0,0 +1,245 @@
+package jobs
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func journalLines(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Count(data, []byte("\n"))
+}
+
+func TestStoreCompactsOnOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs", "jobs.jsonl")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		if err := s.Put(&Job{ID: id, Status: StatusPending}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := s.Update("a", func(job *Job) { job.Status = StatusDownloading }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Update("b", func(job *Job) { job.Status = StatusFailed; job.Attempts = 3 }); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("c"); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	if n := journalLines(t, path); n != 10 {
+		t.Fatalf("journal has %d lines before compaction, want 10", n)
+	}
+
+	// A crash can leave a partial line behind
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"job":{"id":"d","sta`)
+	f.Close()
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer s.Close()
+
+	if n := journalLines(t, path); n != 2 {
+		t.Errorf("journal has %d lines after compaction, want 2", n)
+	}
+	list := s.List()
+	if len(list) != 2 || list[0].ID != "a" || list[1].ID != "b" {
+		t.Fatalf("jobs = %v, want a and b", list)
+	}
+	if list[0].Status != StatusDownloading || list[1].Status != StatusFailed || list[1].Attempts != 3 {
+		t.Errorf("jobs = %+v, %+v", list[0], list[1])
+	}
+
+	// The reopened journal still takes appends
+	if err := s.Put(&Job{ID: "e", Status: StatusPending}); err != nil {
+		t.Fatal(err)
+	}
+	if n := journalLines(t, path); n != 3 {
+		t.Errorf("journal has %d lines after an append, want 3", n)
+	}
+}
+
+func TestStorePrune(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.jsonl")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer s.Close()
+
+	for _, job := range []*Job{
+		{ID: "done", Status: StatusCompleted},
+		{ID: "failed", Status: StatusFailed},
+		{ID: "cancelled", Status: StatusCancelled},
+		{ID: "paused", Status: StatusPaused},
+		{ID: "pending", Status: StatusPending},
+	} {
+		if err := s.Put(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Update("pending", func(job *Job) { job.Status = StatusDownloading }); err != nil {
+		t.Fatal(err)
+	}
+
+	var deleted []string
+	s.OnChange(func(previous, current *Job) {
+		if current == nil {
+			deleted = append(deleted, previous.ID)
+		}
+	})
+
+	removed, err := s.Prune(-time.Minute)
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	sort.Strings(deleted)
+	if removed != 3 || strings.Join(deleted, ",") != "cancelled,done,failed" {
+		t.Errorf("pruned %d jobs, deleted %v; want cancelled, done and failed", removed, deleted)
+	}
+	if n := journalLines(t, path); n != 2 {
+		t.Errorf("journal has %d lines after pruning, want 2", n)
+	}
+
+	s.Close()
+	s, err = Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if _, ok := s.Get("done"); ok {
+		t.Error("pruned job came back after reopening")
+	}
+	if _, ok := s.Get("paused"); !ok {
+		t.Error("paused job was pruned")
+	}
+	if unfinished := s.Unfinished(); len(unfinished) != 1 || unfinished[0].ID != "pending" {
+		t.Errorf("unfinished = %v, want pending", unfinished)
+	}
+}
+
+func TestStorePruneCollections(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.jsonl")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer s.Close()
+
+	for _, job := range []*Job{
+		// A running collection keeps its finished children
+		{ID: "running", Kind: KindCollection, Status: StatusDownloading, Children: []string{"running-failed", "running-pending"}},
+		{ID: "running-failed", Status: StatusFailed, ParentID: "running"},
+		{ID: "running-pending", Status: StatusPending, ParentID: "running"},
+		// A finished collection goes together with its children
+		{ID: "done", Kind: KindCollection, Status: StatusFailed, Children: []string{"done-completed", "done-cancelled"}},
+		{ID: "done-completed", Status: StatusCompleted, ParentID: "done"},
+		{ID: "done-cancelled", Status: StatusCancelled, ParentID: "done"},
+		// A child being retried holds back its finished collection
+		{ID: "retried", Kind: KindCollection, Status: StatusFailed, Children: []string{"retried-pending"}},
+		{ID: "retried-pending", Status: StatusPending, ParentID: "retried"},
+		// A child whose collection is gone is pruned on its own
+		{ID: "orphan", Status: StatusCompleted, ParentID: "gone"},
+	} {
+		if err := s.Put(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := s.Prune(-time.Minute)
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if removed != 4 {
+		t.Errorf("pruned %d jobs, want 4", removed)
+	}
+
+	var left []string
+	for _, job := range s.List() {
+		left = append(left, job.ID)
+	}
+	sort.Strings(left)
+	if got, want := strings.Join(left, ","), "retried,retried-pending,running,running-failed,running-pending"; got != want {
+		t.Errorf("jobs left = %s, want %s", got, want)
+	}
+
+	// Nothing left to remove doesn't rewrite the journal
+	if err := s.Put(&Job{ID: "new", Status: StatusPending}); err != nil {
+		t.Fatal(err)
+	}
+	before := journalLines(t, path)
+	if removed, err := s.Prune(-time.Minute); err != nil || removed != 0 {
+		t.Fatalf("Prune = %d, %v; want nothing removed", removed, err)
+	}
+	if n := journalLines(t, path); n != before {
+		t.Errorf("journal has %d lines after an empty prune, want %d", n, before)
+	}
+}
+
+func TestStoreCompactsLongJournal(t *testing.T) {
+	defer func(n int) { compactMinLines = n }(compactMinLines)
+	compactMinLines = 10
+
+	path := filepath.Join(t.TempDir(), "jobs.jsonl")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer s.Close()
+
+	if err := s.Put(&Job{ID: "a", Status: StatusPending}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 8; i++ {
+		if _, err := s.Update("a", func(job *Job) { job.Attempts++ }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := journalLines(t, path); n != 9 {
+		t.Fatalf("journal has %d lines below the threshold, want 9", n)
+	}
+
+	if _, err := s.Update("a", func(job *Job) { job.Attempts++ }); err != nil {
+		t.Fatal(err)
+	}
+	if n := journalLines(t, path); n != 1 {
+		t.Errorf("journal has %d lines past the threshold, want 1", n)
+	}
+
+	// Appends continue on the compacted journal
+	if err := s.Put(&Job{ID: "b", Status: StatusPending}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	s, err = Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if job, ok := s.Get("a"); !ok || job.Attempts != 9 {
+		t.Errorf("a = %+v after reopening, want 9 attempts", job)
+	}
+	if _, ok := s.Get("b"); !ok {
+		t.Error("b is missing after reopening")
+	}
+}
@@ -0,0 +1,116 @@
+// Package library moves finished downloads into the downloads directory.
+package library
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Policies for a destination file that already exists
+const (
+	ExistsSkip      = "skip"
+	ExistsOverwrite = "overwrite"
+	ExistsSuffix    = "suffix"
+)
+
+// maxSuffix bounds the search for a free "name (n)" filename
+const maxSuffix = 1000
+
+var (
+	ExistsPolicies = []string{ExistsSkip, ExistsOverwrite, ExistsSuffix}
+
+	ErrExists = errors.New("file already exists")
+)
+
+// Find looks for an existing file named name in dir with any of the given
+// extensions and returns its path.
+func Find(dir, name string, exts ...string) (string, bool) {
+	for _, ext := range exts {
+		path := filepath.Join(dir, name+ext)
+		if _, err := os.Stat(path); err == nil {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// Place moves the file at src to dir/name+ext and returns its final path.
+// With the skip policy an existing file is left alone and ErrExists is
+// returned along with its path, the suffix policy picks the first free
+// "name (n)" filename instead, and the overwrite policy replaces it.
+func Place(src, dir, name, ext, policy string) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, name+ext)
+	switch policy {
+	case ExistsOverwrite:
+	case ExistsSuffix:
+		for n := 1; exists(path); n++ {
+			if n > maxSuffix {
+				return "", fmt.Errorf("%w: no free filename for %s", ErrExists, name+ext)
+			}
+			path = filepath.Join(dir, fmt.Sprintf("%s (%d)%s", name, n, ext))
+		}
+	default:
+		if exists(path) {
+			return path, ErrExists
+		}
+	}
+
+	if err := move(src, path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// move renames src to dst. When they are on different filesystems the data
+// is copied to a temporary file next to dst first, so dst never holds a
+// partially written file.
+func move(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return err
+	}
+
+	in.Close()
+	return os.Remove(src)
+}
@@ -0,0 +1,111 @@
+package library
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestPlace(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   string
+		existing []string
+		wantPath string
+		wantErr  error
+		// wantData is what ends up at wantPath
+		wantData string
+	}{
+		{name: "new file", policy: ExistsSkip, wantPath: "Track.flac", wantData: "new"},
+		{name: "skip", policy: ExistsSkip, existing: []string{"Track.flac"}, wantPath: "Track.flac", wantErr: ErrExists, wantData: "old"},
+		{name: "unknown policy skips", policy: "", existing: []string{"Track.flac"}, wantPath: "Track.flac", wantErr: ErrExists, wantData: "old"},
+		{name: "overwrite", policy: ExistsOverwrite, existing: []string{"Track.flac"}, wantPath: "Track.flac", wantData: "new"},
+		{name: "suffix", policy: ExistsSuffix, existing: []string{"Track.flac"}, wantPath: "Track (1).flac", wantData: "new"},
+		{
+			name:     "suffix skips taken names",
+			policy:   ExistsSuffix,
+			existing: []string{"Track.flac", "Track (1).flac", "Track (2).flac"},
+			wantPath: "Track (3).flac",
+			wantData: "new",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmp := t.TempDir()
+			dir := filepath.Join(tmp, "Artist", "Release")
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range tt.existing {
+				writeFile(t, filepath.Join(dir, name), "old")
+			}
+			src := filepath.Join(tmp, "download.temp")
+			writeFile(t, src, "new")
+
+			path, err := Place(src, dir, "Track", ".flac", tt.policy)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if want := filepath.Join(dir, tt.wantPath); path != want {
+				t.Errorf("path = %s, want %s", path, want)
+			}
+			if data := readFile(t, path); data != tt.wantData {
+				t.Errorf("%s holds %q, want %q", tt.wantPath, data, tt.wantData)
+			}
+			_, statErr := os.Stat(src)
+			if moved := os.IsNotExist(statErr); moved != (tt.wantErr == nil) {
+				t.Errorf("source moved = %v, want %v", moved, tt.wantErr == nil)
+			}
+			for _, name := range tt.existing {
+				if name != tt.wantPath && readFile(t, filepath.Join(dir, name)) != "old" {
+					t.Errorf("%s was changed", name)
+				}
+			}
+		})
+	}
+}
+
+func TestPlaceCreatesDirectory(t *testing.T) {
+	tmp := t.TempDir()
+	src := filepath.Join(tmp, "download.temp")
+	writeFile(t, src, "new")
+
+	dir := filepath.Join(tmp, "a", "b", "c")
+	path, err := Place(src, dir, "Track", ".mp3", ExistsSkip)
+	if err != nil {
+		t.Fatalf("Place: %v", err)
+	}
+	if readFile(t, path) != "new" {
+		t.Errorf("%s has the wrong content", path)
+	}
+}
+
+func TestFind(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "Track.m4a"), "")
+
+	if path, ok := Find(dir, "Track", ".flac", ".m4a"); !ok || path != filepath.Join(dir, "Track.m4a") {
+		t.Errorf("Find = %s, %v; want the m4a file", path, ok)
+	}
+	if _, ok := Find(dir, "Track", ".mp3"); ok {
+		t.Error("Find matched a missing extension")
+	}
+}
@@ -0,0 +1,278 @@
+// Package pipeline downloads a track, tags it and moves it into the
+// downloads directory. The server and the command line tool share it.
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/unspok3n/beatportdl-ui/config"
+	"github.com/unspok3n/beatportdl-ui/internal/beatport"
+	"github.com/unspok3n/beatportdl-ui/internal/downloader"
+	"github.com/unspok3n/beatportdl-ui/internal/library"
+	"github.com/unspok3n/beatportdl-ui/internal/ratelimit"
+	"github.com/unspok3n/beatportdl-ui/internal/server"
+	"github.com/unspok3n/beatportdl-ui/internal/tagger"
+)
+
+// Error is a failed step of the pipeline
+type Error struct {
+	Step string
+	Err  error
+	// Local marks failures on this machine, such as tagging the file or
+	// moving it into place, which trying again won't fix
+	Local bool
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("error %s: %v", e.Step, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Track is a single track to download
+type Track struct {
+	Link *beatport.Link
+	// Directory is relative to the downloads directory. When empty, the
+	// release directory is used if downloads are sorted by context.
+	Directory string
+	// TempPath is where the download is kept until it is moved into place
+	TempPath string
+	// Quality overrides the configured download quality
+	Quality string
+}
+
+// Result is where a track ended up
+type Result struct {
+	Path   string
+	Format string
+	// Skipped is set when the file already exists, Path is then the
+	// existing file
+	Skipped bool
+}
+
+// Hooks follow a track through the pipeline, any of them may be nil
+type Hooks struct {
+	// Track is called once the track is looked up
+	Track func(track *beatport.Track)
+	// Fallback is called when a quality isn't available and the next lower
+	// one is tried
+	Fallback func(from, to string)
+	// Source is called for every download URL obtained
+	Source func(quality string, download *beatport.TrackDownload)
+	// Progress receives the bytes written so far
+	Progress downloader.ProgressFunc
+	// Logf reports problems that don't fail the track, such as a missing
+	// cover
+	Logf func(format string, v ...interface{})
+}
+
+// Pipeline runs tracks from a single store with a fixed config
+type Pipeline struct {
+	Config    *config.AppConfig
+	Client    *beatport.Beatport
+	Covers    *tagger.CoverCache
+	Bandwidth *ratelimit.Limiter
+	Hooks     Hooks
+}
+
+func (p *Pipeline) logf(format string, v ...interface{}) {
+	if p.Hooks.Logf != nil {
+		p.Hooks.Logf(format, v...)
+	}
+}
+
+// qualitySource returns the download URLs of a track. It walks down the
+// quality chain while qualities are unavailable. The signed download
+// location expires, so the downloader asks again whenever it finds the
+// previous one rejected, starting over from the quality chosen last time.
+func qualitySource(qualities []string, hooks Hooks, downloadURL func(ctx context.Context, quality string) (*beatport.TrackDownload, error)) downloader.URLSource {
+	chosen := 0
+	return func(ctx context.Context) (string, error) {
+		if len(qualities) == 0 {
+			return "", errors.New("no download quality")
+		}
+		var downloadInfo *beatport.TrackDownload
+		var err error
+		for {
+			downloadInfo, err = downloadURL(ctx, qualities[chosen])
+			if !QualityUnavailable(err) || chosen == len(qualities)-1 {
+				break
+			}
+			if hooks.Fallback != nil {
+				hooks.Fallback(qualities[chosen], qualities[chosen+1])
+			}
+			chosen++
+		}
+		if err != nil {
+			return "", &Error{Step: "getting download URL", Err: err}
+		}
+		if downloadInfo == nil || downloadInfo.Location == "" {
+			return "", errors.New("empty download URL")
+		}
+		if hooks.Source != nil {
+			hooks.Source(qualities[chosen], downloadInfo)
+		}
+		return downloadInfo.Location, nil
+	}
+}
+
+// Run downloads, tags and places a track. A partial download left in
+// TempPath by an earlier run is resumed.
+func (p *Pipeline) Run(ctx context.Context, t Track) (*Result, error) {
+	cfg := p.Config
+	trackInfo, err := p.Client.GetTrack(ctx, t.Link.ID)
+	if err != nil {
+		return nil, &Error{Step: "getting track info", Err: err}
+	}
+	if p.Hooks.Track != nil {
+		p.Hooks.Track(trackInfo)
+	}
+
+	release, err := p.Client.GetRelease(ctx, trackInfo.Release.ID)
+	if err != nil {
+		return nil, &Error{Step: "getting release info", Err: err}
+	}
+	// Tracks listed inside collections come without the release track count
+	// used to pad their number
+	trackInfo.Release.TrackCount = release.TrackCount
+
+	directory := t.Directory
+	if directory == "" && cfg.Downloads.SortByContext {
+		directory = release.DirectoryName(cfg.NamingPreferences(cfg.Naming.ReleaseTemplate))
+	}
+	directory = filepath.Join(cfg.Downloads.Directory, directory)
+	name := trackInfo.Filename(cfg.NamingPreferences(cfg.Naming.TrackTemplate))
+
+	if cfg.Downloads.FileExistsPolicy == library.ExistsSkip {
+		if existing, ok := library.Find(directory, name, "."+tagger.FormatFLAC, "."+tagger.FormatM4A); ok {
+			return &Result{Path: existing, Skipped: true}, nil
+		}
+	}
+
+	filePath := t.TempPath
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return nil, &Error{Step: "creating download directory", Err: err, Local: true}
+	}
+
+	quality := t.Quality
+	if quality == "" {
+		quality = cfg.Downloads.Quality
+	}
+	qualities := cfg.QualityChain(quality)
+
+	source := qualitySource(qualities, p.Hooks, func(ctx context.Context, quality string) (*beatport.TrackDownload, error) {
+		return p.Client.DownloadTrack(ctx, t.Link.ID, quality)
+	})
+
+	d := downloader.New(&http.Client{})
+	d.SetLimiter(p.Bandwidth)
+	if _, err := d.Download(ctx, filePath, source, p.Hooks.Progress); err != nil {
+		return nil, err
+	}
+
+	format, err := tagger.DetectFormat(filePath)
+	if err != nil {
+		return nil, &Error{Step: "detecting audio format", Err: err, Local: true}
+	}
+
+	// The cover file only belongs in directories dedicated to the release
+	keepCover := cfg.Cover.Keep && filepath.Base(directory) == release.DirectoryName(cfg.NamingPreferences(cfg.Naming.ReleaseTemplate))
+
+	var cover []byte
+	if (cfg.Tagging.Enabled && cfg.Cover.Embed) || keepCover {
+		cover, err = p.Covers.Get(ctx, release)
+		if err != nil {
+			p.logf("Error getting cover for release %d: %v", release.ID, err)
+		}
+	}
+
+	if cfg.Tagging.Enabled {
+		var embedded []byte
+		if cfg.Cover.Embed {
+			embedded = cover
+		}
+		tg := tagger.New(cfg.Tagging.Mappings, cfg.NamingPreferences(""))
+		if err := tg.Tag(filePath, format, trackInfo, release, embedded); err != nil {
+			return nil, &Error{Step: "tagging file", Err: err, Local: true}
+		}
+	}
+
+	finalPath, err := library.Place(filePath, directory, name, "."+format, cfg.Downloads.FileExistsPolicy)
+	if errors.Is(err, library.ErrExists) {
+		Remove(filePath)
+		return &Result{Path: finalPath, Format: format, Skipped: true}, nil
+	}
+	if err != nil {
+		return nil, &Error{Step: "moving file into place", Err: err, Local: true}
+	}
+
+	if keepCover && len(cover) > 0 {
+		if err := tagger.SaveCover(directory, cover); err != nil {
+			p.logf("Error saving cover for release %d: %v", release.ID, err)
+		}
+	}
+	return &Result{Path: finalPath, Format: format}, nil
+}
+
+// Remove deletes a download kept at path along with its partial file
+func Remove(path string) error {
+	if err := downloader.Remove(path); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// QualityUnavailable reports whether a download URL request failed because
+// the account or the track does not offer the requested quality. A missing
+// track or a malformed request fails the same way at any quality.
+func QualityUnavailable(err error) bool {
+	return errors.Is(err, beatport.ErrNotAvailable)
+}
+
+// StatusCode returns the HTTP status code behind a failure of the API, the
+// file host or the server.
+func StatusCode(err error) (int, bool) {
+	var apiErr *beatport.ServerError
+	var statusErr *downloader.StatusError
+	var serverErr *server.ServerError
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr.Code, true
+	case errors.As(err, &statusErr):
+		return statusErr.Code, true
+	case errors.As(err, &serverErr):
+		return serverErr.Code, true
+	}
+	return 0, false
+}
+
+// Retryable reports whether a failed track may succeed when tried again:
+// timeouts, rate limiting and server side errors, including dropped
+// connections. Other client errors and local failures are final.
+func Retryable(err error) bool {
+	var pipelineErr *Error
+	if errors.As(err, &pipelineErr) && pipelineErr.Local {
+		return false
+	}
+	code, ok := StatusCode(err)
+	if !ok {
+		return true
+	}
+	switch {
+	case code == http.StatusRequestTimeout, code == http.StatusTooManyRequests:
+		return true
+	case code >= http.StatusInternalServerError:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,196 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/unspok3n/beatportdl-ui/internal/beatport"
+	"github.com/unspok3n/beatportdl-ui/internal/downloader"
+	"github.com/unspok3n/beatportdl-ui/internal/server"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "dropped connection", err: io.ErrUnexpectedEOF, want: true},
+		{name: "api server error", err: &Error{Step: "getting track info", Err: &beatport.ServerError{Code: http.StatusBadGateway}}, want: true},
+		{name: "api rate limited", err: &Error{Step: "getting track info", Err: &beatport.ServerError{Code: http.StatusTooManyRequests}}, want: true},
+		{name: "api not found", err: &Error{Step: "getting track info", Err: &beatport.ServerError{Code: http.StatusNotFound}}},
+		{name: "file host timeout", err: fmt.Errorf("download failed after 3 attempts: %w", &downloader.StatusError{Code: http.StatusRequestTimeout}), want: true},
+		{name: "file host not found", err: &downloader.StatusError{Code: http.StatusNotFound}},
+		{name: "bad request", err: server.NewServerError(http.StatusBadRequest, "Unsupported link type: release")},
+		{name: "tagging", err: &Error{Step: "tagging file", Err: errors.New("unsupported format"), Local: true}},
+		{name: "format detection", err: &Error{Step: "detecting audio format", Err: io.ErrUnexpectedEOF, Local: true}},
+		{name: "placing", err: &Error{Step: "moving file into place", Err: os.ErrPermission, Local: true}},
+	}
+
+	for _, tt := range tests {
+		if got := Retryable(tt.err); got != tt.want {
+			t.Errorf("%s: Retryable(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRetryableStatusCodes(t *testing.T) {
+	tests := []struct {
+		code int
+		want bool
+	}{
+		{code: http.StatusRequestTimeout, want: true},
+		{code: http.StatusTooManyRequests, want: true},
+		{code: http.StatusInternalServerError, want: true},
+		{code: http.StatusBadGateway, want: true},
+		{code: http.StatusServiceUnavailable, want: true},
+		{code: http.StatusGatewayTimeout, want: true},
+		{code: http.StatusBadRequest},
+		{code: http.StatusUnauthorized},
+		{code: http.StatusForbidden},
+		{code: http.StatusNotFound},
+		{code: http.StatusGone},
+	}
+
+	// The API and the file host are judged alike, and the same failure is
+	// final when it happened on this machine
+	for _, tt := range tests {
+		for _, err := range []error{
+			&Error{Step: "getting download URL", Err: &beatport.ServerError{Code: tt.code}},
+			&downloader.StatusError{Code: tt.code},
+		} {
+			if got := Retryable(err); got != tt.want {
+				t.Errorf("Retryable(%v) = %v, want %v", err, got, tt.want)
+			}
+		}
+		if err := (&Error{Step: "moving file into place", Err: &downloader.StatusError{Code: tt.code}, Local: true}); Retryable(err) {
+			t.Errorf("Retryable(%v) = true for a local failure", err)
+		}
+	}
+}
+
+func TestQualityUnavailable(t *testing.T) {
+	tests := []struct {
+		code int
+		want bool
+	}{
+		{code: http.StatusForbidden, want: true},
+		{code: http.StatusUnavailableForLegalReasons, want: true},
+		{code: http.StatusBadRequest},
+		{code: http.StatusNotFound},
+		{code: http.StatusTooManyRequests},
+		{code: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		if got := QualityUnavailable(&beatport.ServerError{Code: tt.code}); got != tt.want {
+			t.Errorf("QualityUnavailable(%d) = %v, want %v", tt.code, got, tt.want)
+		}
+	}
+	if QualityUnavailable(nil) {
+		t.Error("QualityUnavailable(nil) = true")
+	}
+}
+
+func TestQualitySourceRefresh(t *testing.T) {
+	// The file host rejects the paths ending in "expired" like an expired
+	// signed URL
+	host := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "expired") {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		w.Write([]byte("audio"))
+	}))
+	defer host.Close()
+
+	tests := []struct {
+		name string
+		// urls lists the paths the API answers with for every quality, in
+		// order and repeating the last one. An empty path means the quality
+		// isn't available.
+		urls      map[string][]string
+		calls     []string
+		fallbacks []string
+		sources   []string
+	}{
+		{
+			name: "refresh keeps the fallback quality",
+			urls: map[string][]string{
+				"lossless": {""},
+				"high":     {"/high/expired", "/high/fresh"},
+			},
+			calls:     []string{"lossless", "high", "high"},
+			fallbacks: []string{"lossless>high"},
+			sources:   []string{"high", "high"},
+		},
+		{
+			name: "refresh falls back further",
+			urls: map[string][]string{
+				"lossless": {""},
+				"high":     {"/high/expired", ""},
+				"medium":   {"/medium"},
+			},
+			calls:     []string{"lossless", "high", "high", "medium"},
+			fallbacks: []string{"lossless>high", "high>medium"},
+			sources:   []string{"high", "medium"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls, fallbacks, sources []string
+			hooks := Hooks{
+				Fallback: func(from, to string) { fallbacks = append(fallbacks, from+">"+to) },
+				Source:   func(quality string, _ *beatport.TrackDownload) { sources = append(sources, quality) },
+			}
+			source := qualitySource([]string{"lossless", "high", "medium"}, hooks, func(ctx context.Context, quality string) (*beatport.TrackDownload, error) {
+				urls := tt.urls[quality]
+				path := urls[min(countOf(calls, quality), len(urls)-1)]
+				calls = append(calls, quality)
+				if path == "" {
+					return nil, &beatport.ServerError{Code: http.StatusForbidden}
+				}
+				return &beatport.TrackDownload{Location: host.URL + path}, nil
+			})
+
+			path := filepath.Join(t.TempDir(), "track")
+			if _, err := downloader.New(host.Client()).Download(context.Background(), path, source, nil); err != nil {
+				t.Fatalf("Download: %v", err)
+			}
+			if data, _ := os.ReadFile(path); string(data) != "audio" {
+				t.Errorf("downloaded %q, want %q", data, "audio")
+			}
+			for _, list := range []struct {
+				name      string
+				got, want []string
+			}{
+				{name: "download URL requests", got: calls, want: tt.calls},
+				{name: "fallbacks", got: fallbacks, want: tt.fallbacks},
+				{name: "sources", got: sources, want: tt.sources},
+			} {
+				if strings.Join(list.got, " ") != strings.Join(list.want, " ") {
+					t.Errorf("%s = %v, want %v", list.name, list.got, list.want)
+				}
+			}
+		})
+	}
+}
+
+func countOf(list []string, value string) int {
+	n := 0
+	for _, v := range list {
+		if v == value {
+			n++
+		}
+	}
+	return n
+}
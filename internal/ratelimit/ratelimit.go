@@ -0,0 +1,99 @@
+// Package ratelimit implements a token bucket shared by concurrent callers.
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket that refills at a fixed rate up to its burst
+// size. Callers that find the bucket empty reserve their tokens anyway and
+// wait until they are due, so waiting callers are served in order. A nil
+// Limiter, or one with a rate that is not positive, doesn't limit anything.
+type Limiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	mutex  sync.Mutex
+}
+
+// New returns a limiter allowing rate tokens per second with bursts of up
+// to burst tokens. A rate that is not positive means no limit until
+// SetLimit sets one.
+func New(rate float64, burst int) *Limiter {
+	l := &Limiter{last: time.Now()}
+	l.set(rate, burst)
+	l.tokens = l.burst
+	return l
+}
+
+// SetLimit changes the rate and burst size. Tokens already in the bucket
+// are kept up to the new burst size.
+func (l *Limiter) SetLimit(rate float64, burst int) {
+	if l == nil {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.refill(time.Now())
+	l.set(rate, burst)
+	l.tokens = min(l.tokens, l.burst)
+}
+
+func (l *Limiter) set(rate float64, burst int) {
+	l.rate = rate
+	l.burst = float64(max(burst, 1))
+}
+
+// Wait blocks until a single token is available.
+func (l *Limiter) Wait(ctx context.Context) error {
+	return l.WaitN(ctx, 1)
+}
+
+// WaitN blocks until n tokens are available. Requests larger than the
+// burst size are split into several waits.
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+	for n > 0 {
+		l.mutex.Lock()
+		if l.rate <= 0 {
+			l.mutex.Unlock()
+			return nil
+		}
+		chunk := min(n, int(l.burst))
+		now := time.Now()
+		l.refill(now)
+		l.tokens -= float64(chunk)
+		var delay time.Duration
+		if l.tokens < 0 {
+			delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+		}
+		l.mutex.Unlock()
+
+		if delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return context.Cause(ctx)
+			case <-timer.C:
+			}
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+// refill adds the tokens earned since the last call. The caller must hold
+// the lock.
+func (l *Limiter) refill(now time.Time) {
+	elapsed := now.Sub(l.last).Seconds()
+	l.last = now
+	if elapsed > 0 {
+		l.tokens = min(l.tokens+elapsed*l.rate, l.burst)
+	}
+}
@@ -0,0 +1,98 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// timed returns how long fn took
+func timed(t *testing.T, fn func() error) time.Duration {
+	t.Helper()
+	start := time.Now()
+	if err := fn(); err != nil {
+		t.Fatalf("wait failed: %v", err)
+	}
+	return time.Since(start)
+}
+
+func TestLimiterWait(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		rate     float64
+		burst    int
+		waits    []int
+		min, max time.Duration
+	}{
+		{name: "burst is free", rate: 10, burst: 5, waits: []int{1, 1, 1, 1, 1}, max: 50 * time.Millisecond},
+		{name: "beyond the burst", rate: 10, burst: 5, waits: []int{1, 1, 1, 1, 1, 1}, min: 80 * time.Millisecond, max: 500 * time.Millisecond},
+		{name: "rate", rate: 50, burst: 1, waits: []int{1, 1, 1, 1, 1, 1}, min: 80 * time.Millisecond, max: 500 * time.Millisecond},
+		{name: "larger than the burst", rate: 100, burst: 10, waits: []int{30}, min: 150 * time.Millisecond, max: time.Second},
+		{name: "no rate", rate: 0, burst: 1, waits: []int{100, 100}, max: 50 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(tt.rate, tt.burst)
+			elapsed := timed(t, func() error {
+				for _, n := range tt.waits {
+					if err := l.WaitN(ctx, n); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if elapsed < tt.min || elapsed > tt.max {
+				t.Errorf("waits took %s, want between %s and %s", elapsed, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestLimiterCancel(t *testing.T) {
+	l := New(1, 1)
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := l.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want the context's error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("cancelled wait took %s", elapsed)
+	}
+}
+
+func TestLimiterSetLimit(t *testing.T) {
+	l := New(0, 1)
+	l.SetLimit(1, 1)
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx); err == nil {
+		t.Error("second wait was not limited after SetLimit")
+	}
+
+	// Lifting the limit releases new callers right away
+	l.SetLimit(0, 1)
+	elapsed := timed(t, func() error { return l.WaitN(context.Background(), 1000) })
+	if elapsed > 50*time.Millisecond {
+		t.Errorf("unlimited wait took %s", elapsed)
+	}
+}
+
+func TestNilLimiter(t *testing.T) {
+	var l *Limiter
+	l.SetLimit(1, 1)
+	if err := l.WaitN(context.Background(), 10); err != nil {
+		t.Errorf("nil limiter returned %v", err)
+	}
+}
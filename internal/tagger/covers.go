@@ -0,0 +1,156 @@
+package tagger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/unspok3n/beatportdl-ui/internal/beatport"
+)
+
+const (
+	CoverFilename = "cover.jpg"
+
+	defaultCoverCacheSize = 32
+	// coverFetchTimeout bounds a cover download, which runs on its own so
+	// a stopped job doesn't fail it for every other track of the release
+	coverFetchTimeout = time.Minute
+)
+
+type coverEntry struct {
+	ready chan struct{}
+	data  []byte
+	err   error
+}
+
+// CoverCache downloads release artwork and keeps the most recently used
+// covers in memory, so every track of a release shares a single fetch.
+type CoverCache struct {
+	client  *http.Client
+	size    string
+	limit   int
+	entries map[int64]*coverEntry
+	order   []int64
+	mutex   sync.Mutex
+}
+
+// NewCoverCache returns a cache fetching covers in the given size, e.g.
+// "1400x1400".
+func NewCoverCache(client *http.Client, size string) *CoverCache {
+	if client == nil {
+		client = &http.Client{}
+	}
+	return &CoverCache{
+		client:  client,
+		size:    size,
+		limit:   defaultCoverCacheSize,
+		entries: make(map[int64]*coverEntry),
+	}
+}
+
+// Get returns the cover of a release. Concurrent calls for the same
+// release wait for the same download, which isn't tied to the context of
+// the call that started it.
+func (c *CoverCache) Get(ctx context.Context, release *beatport.Release) ([]byte, error) {
+	c.mutex.Lock()
+	entry, ok := c.entries[release.ID]
+	if !ok {
+		entry = &coverEntry{ready: make(chan struct{})}
+		c.entries[release.ID] = entry
+		c.order = append(c.order, release.ID)
+		c.evict()
+	}
+	c.mutex.Unlock()
+
+	if !ok {
+		go c.load(context.WithoutCancel(ctx), release, entry)
+	}
+
+	select {
+	case <-entry.ready:
+		return entry.data, entry.err
+	case <-ctx.Done():
+		return nil, context.Cause(ctx)
+	}
+}
+
+// load fetches the cover of an entry and marks it ready
+func (c *CoverCache) load(ctx context.Context, release *beatport.Release, entry *coverEntry) {
+	ctx, cancel := context.WithTimeout(ctx, coverFetchTimeout)
+	defer cancel()
+
+	entry.data, entry.err = c.fetch(ctx, release)
+	close(entry.ready)
+	if entry.err != nil {
+		// Failed fetches are not cached so the next track can try again
+		c.mutex.Lock()
+		if c.entries[release.ID] == entry {
+			c.remove(release.ID)
+		}
+		c.mutex.Unlock()
+	}
+}
+
+// remove drops a cover from the cache. The caller must hold the lock.
+func (c *CoverCache) remove(id int64) {
+	delete(c.entries, id)
+	for i, cached := range c.order {
+		if cached == id {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// evict drops the oldest covers once the cache is over its limit. The
+// caller must hold the lock.
+func (c *CoverCache) evict() {
+	for len(c.order) > c.limit {
+		oldest := c.order[0]
+		c.order = c.order[1:]
+		delete(c.entries, oldest)
+	}
+}
+
+func (c *CoverCache) fetch(ctx context.Context, release *beatport.Release) ([]byte, error) {
+	coverUrl := release.Image.URI
+	if release.Image.DynamicURI != "" {
+		coverUrl = release.Image.FormattedUrl(c.size)
+	}
+	if coverUrl == "" {
+		return nil, fmt.Errorf("release %d has no cover", release.ID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, coverUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cover request failed with status code: %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// SaveCover writes the cover to cover.jpg in dir unless the file already
+// exists.
+func SaveCover(dir string, data []byte) error {
+	path := filepath.Join(dir, CoverFilename)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,68 @@
+package tagger
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/unspok3n/beatportdl-ui/internal/beatport"
+)
+
+func TestCoverCacheOutlivesCancelledCaller(t *testing.T) {
+	release := make(chan struct{})
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		<-release
+		w.Write([]byte("cover"))
+	}))
+	defer srv.Close()
+
+	covers := NewCoverCache(srv.Client(), "500x500")
+	rel := &beatport.Release{ID: 1, Image: beatport.Image{URI: srv.URL}}
+
+	// The first track starts the download, then its job is stopped
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := covers.Get(ctx, rel)
+		first <- err
+	}()
+	for requests.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	second := make(chan []byte, 1)
+	go func() {
+		data, err := covers.Get(context.Background(), rel)
+		if err != nil {
+			t.Errorf("Get: %v", err)
+		}
+		second <- data
+	}()
+
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled Get = %v, want context.Canceled", err)
+	}
+	close(release)
+
+	if data := <-second; string(data) != "cover" {
+		t.Errorf("waiting Get = %q, want the cover", data)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("cover fetched %d times, want once", n)
+	}
+
+	// The cover is cached for the rest of the release
+	if data, err := covers.Get(context.Background(), rel); err != nil || string(data) != "cover" {
+		t.Errorf("cached Get = %q, %v", data, err)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("cover fetched %d times, want once", n)
+	}
+}
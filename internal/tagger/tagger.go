@@ -0,0 +1,170 @@
+// Package tagger writes Beatport track and release metadata into
+// downloaded audio files using the configured tag mappings.
+package tagger
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/unspok3n/beatportdl-ui/internal/beatport"
+	"github.com/unspok3n/beatportdl-ui/internal/taglib"
+)
+
+const (
+	FormatFLAC = "flac"
+	FormatM4A  = "m4a"
+
+	// rawTagSuffix marks M4A tags that are written as freeform atoms with
+	// their name left as is instead of being converted to uppercase
+	rawTagSuffix = "_raw"
+)
+
+var (
+	ErrUnknownFormat = errors.New("unknown audio format")
+)
+
+type Tagger struct {
+	// Mappings maps an audio format to the tag written for every
+	// supported field, e.g. mappings["flac"]["track_name"] = "TITLE"
+	Mappings map[string]map[string]string
+	Naming   beatport.NamingPreferences
+}
+
+func New(mappings map[string]map[string]string, naming beatport.NamingPreferences) *Tagger {
+	return &Tagger{
+		Mappings: mappings,
+		Naming:   naming,
+	}
+}
+
+// DetectFormat inspects the header of an audio file and returns its
+// format.
+func DetectFormat(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnknownFormat, err)
+	}
+
+	switch {
+	case bytes.Equal(header[:4], []byte("fLaC")):
+		return FormatFLAC, nil
+	case bytes.Equal(header[4:8], []byte("ftyp")):
+		return FormatM4A, nil
+	default:
+		return "", ErrUnknownFormat
+	}
+}
+
+// Values resolves every supported tag mapping field for a track. The
+// release must be the full release the track belongs to.
+func (t *Tagger) Values(track *beatport.Track, release *beatport.Release) map[string]string {
+	n := t.Naming
+	label := release.Label
+	label.Store = release.Store
+
+	subgenre := ""
+	if track.Subgenre != nil {
+		subgenre = track.Subgenre.Name
+	}
+
+	return map[string]string{
+		"track_id":                  strconv.FormatInt(track.ID, 10),
+		"track_url":                 track.StoreUrl(),
+		"track_name":                track.Title(),
+		"track_artists":             track.Artists.Display(0, ""),
+		"track_remixers":            track.Remixers.Display(0, ""),
+		"track_artists_limited":     track.Artists.Display(n.ArtistsLimit, n.ArtistsShortForm),
+		"track_remixers_limited":    track.Remixers.Display(n.ArtistsLimit, n.ArtistsShortForm),
+		"track_number":              strconv.Itoa(track.Number),
+		"track_number_with_padding": beatport.NumberWithPadding(track.Number, release.TrackCount, n.TrackNumberPadding),
+		"track_number_with_total":   fmt.Sprintf("%d/%d", track.Number, release.TrackCount),
+		"track_genre":               track.Genre.Name,
+		"track_subgenre":            subgenre,
+		"track_genre_with_subgenre": track.GenreWithSubgenre("|"),
+		"track_subgenre_or_genre":   track.SubgenreOrGenre(),
+		"track_key":                 track.Key.Display(n.KeySystem),
+		"track_bpm":                 strconv.Itoa(track.BPM),
+		"track_isrc":                track.ISRC,
+
+		"release_id":                       strconv.FormatInt(release.ID, 10),
+		"release_url":                      release.StoreUrl(),
+		"release_name":                     release.Name.String(),
+		"release_artists":                  release.Artists.Display(0, ""),
+		"release_remixers":                 release.Remixers.Display(0, ""),
+		"release_artists_limited":          release.Artists.Display(n.ArtistsLimit, n.ArtistsShortForm),
+		"release_remixers_limited":         release.Remixers.Display(n.ArtistsLimit, n.ArtistsShortForm),
+		"release_date":                     release.Date,
+		"release_year":                     release.Year(),
+		"release_track_count":              strconv.Itoa(release.TrackCount),
+		"release_track_count_with_padding": beatport.NumberWithPadding(release.TrackCount, release.TrackCount, n.TrackNumberPadding),
+		"release_catalog_number":           release.CatalogNumber.String(),
+		"release_upc":                      release.UPC,
+		"release_label":                    label.Name,
+		"release_label_url":                label.StoreUrl(),
+	}
+}
+
+// Tag replaces the tags of the file at path with the mapped values of the
+// track and release. Only the fields listed in the mappings for the
+// file's format are written. A non-empty cover is embedded as the front
+// cover picture.
+func (t *Tagger) Tag(path, format string, track *beatport.Track, release *beatport.Release, cover []byte) error {
+	mappings, ok := t.Mappings[format]
+	if !ok {
+		return fmt.Errorf("%w: no tag mappings for %s", ErrUnknownFormat, format)
+	}
+
+	file, err := taglib.Read(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	keys, err := file.PropertyKeys()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		file.SetProperty(key, nil)
+	}
+
+	values := t.Values(track, release)
+	for field, tag := range mappings {
+		value := values[field]
+		if value == "" {
+			continue
+		}
+		if format == FormatM4A && strings.HasSuffix(tag, rawTagSuffix) {
+			file.SetItemMp4(strings.TrimSuffix(tag, rawTagSuffix), value)
+			continue
+		}
+		file.SetProperty(strings.ToUpper(tag), &value)
+	}
+
+	if len(cover) > 0 {
+		err := file.SetPicture(&taglib.Picture{
+			MimeType:    http.DetectContentType(cover),
+			PictureType: "Front Cover",
+			Description: "Cover",
+			Data:        cover,
+			Size:        uint(len(cover)),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return file.Save()
+}
@@ -0,0 +1,173 @@
+package tagger
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/unspok3n/beatportdl-ui/config"
+	"github.com/unspok3n/beatportdl-ui/internal/beatport"
+)
+
+const trackJSON = `{
+	"id": 17, "name": "Song", "mix_name": "Extended Mix", "slug": "song", "number": 3,
+	"key": {"name": "A Minor", "letter": "A", "chord_type": {"name": "Minor"}, "camelot_number": 8, "camelot_letter": "A"},
+	"bpm": 124, "genre": {"name": "House"}, "sub_genre": {"name": "Deep"}, "isrc": "GB0000000001",
+	"artists": [{"name": "One"}, {"name": "Two"}, {"name": "Three"}], "remixers": [{"name": "Mixer"}]
+}`
+
+const releaseJSON = `{
+	"id": 5, "name": "The EP", "slug": "the-ep", "catalog_number": "CAT001", "upc": "0001",
+	"artists": [{"name": "One"}], "label": {"id": 9, "name": "Label", "slug": "label"},
+	"new_release_date": "2024-03-01", "track_count": 12
+}`
+
+func fixtures(t *testing.T, store beatport.Store) (*beatport.Track, *beatport.Release) {
+	t.Helper()
+	var track beatport.Track
+	var release beatport.Release
+	if err := json.Unmarshal([]byte(trackJSON), &track); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(releaseJSON), &release); err != nil {
+		t.Fatal(err)
+	}
+	track.Store = store
+	release.Store = store
+	return &track, &release
+}
+
+func TestValues(t *testing.T) {
+	tests := []struct {
+		name   string
+		store  beatport.Store
+		naming beatport.NamingPreferences
+		want   map[string]string
+	}{
+		{
+			name:   "defaults",
+			store:  beatport.StoreBeatport,
+			naming: beatport.NamingPreferences{KeySystem: "standard"},
+			want: map[string]string{
+				"track_id":                  "17",
+				"track_url":                 "https://www.beatport.com/track/song/17",
+				"track_name":                "Song (Extended Mix)",
+				"track_artists":             "One, Two, Three",
+				"track_artists_limited":     "One, Two, Three",
+				"track_remixers":            "Mixer",
+				"track_number":              "3",
+				"track_number_with_padding": "03",
+				"track_number_with_total":   "3/12",
+				"track_genre":               "House",
+				"track_subgenre":            "Deep",
+				"track_genre_with_subgenre": "House | Deep",
+				"track_subgenre_or_genre":   "Deep",
+				"track_key":                 "A Minor",
+				"track_bpm":                 "124",
+				"track_isrc":                "GB0000000001",
+				"release_id":                "5",
+				"release_url":               "https://www.beatport.com/release/the-ep/5",
+				"release_name":              "The EP",
+				"release_date":              "2024-03-01",
+				"release_year":              "2024",
+				"release_track_count":       "12",
+				"release_catalog_number":    "CAT001",
+				"release_upc":               "0001",
+				"release_label":             "Label",
+				"release_label_url":         "https://www.beatport.com/label/label/9",
+			},
+		},
+		{
+			name:  "naming preferences",
+			store: beatport.StoreBeatport,
+			naming: beatport.NamingPreferences{
+				KeySystem:          "camelot",
+				ArtistsLimit:       2,
+				ArtistsShortForm:   "Various Artists",
+				TrackNumberPadding: 3,
+			},
+			want: map[string]string{
+				"track_artists":                    "One, Two, Three",
+				"track_artists_limited":            "Various Artists",
+				"release_artists_limited":          "One",
+				"track_number_with_padding":        "003",
+				"release_track_count_with_padding": "012",
+				"track_key":                        "8A",
+			},
+		},
+		{
+			name:   "beatsource",
+			store:  beatport.StoreBeatsource,
+			naming: beatport.NamingPreferences{KeySystem: "openkey"},
+			want: map[string]string{
+				"track_url":         "https://www.beatsource.com/track/song/17",
+				"release_url":       "https://www.beatsource.com/release/the-ep/5",
+				"release_label_url": "https://www.beatsource.com/label/label/9",
+				"track_key":         "1m",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			track, release := fixtures(t, tt.store)
+			values := New(nil, tt.naming).Values(track, release)
+			for field, want := range tt.want {
+				if got := values[field]; got != want {
+					t.Errorf("%s = %q, want %q", field, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestValuesCoverSupportedFields(t *testing.T) {
+	track, release := fixtures(t, beatport.StoreBeatport)
+	track.Subgenre = nil
+	values := New(nil, beatport.NamingPreferences{}).Values(track, release)
+
+	for _, field := range config.SupportedTagMappingFields {
+		if _, ok := values[field]; !ok {
+			t.Errorf("supported field %s has no value", field)
+		}
+	}
+	if values["track_subgenre"] != "" || values["track_subgenre_or_genre"] != "House" {
+		t.Errorf("without a subgenre: track_subgenre = %q, track_subgenre_or_genre = %q", values["track_subgenre"], values["track_subgenre_or_genre"])
+	}
+}
+
+func TestValuesWithoutMixName(t *testing.T) {
+	track, release := fixtures(t, beatport.StoreBeatport)
+	track.MixName = ""
+	values := New(nil, beatport.NamingPreferences{}).Values(track, release)
+
+	if got := values["track_name"]; got != "Song" {
+		t.Errorf("track_name = %q, want %q", got, "Song")
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   string
+		ok     bool
+	}{
+		{name: "flac", header: "fLaC\x00\x00\x00\x22\x00\x00\x00\x00", want: FormatFLAC, ok: true},
+		{name: "m4a", header: "\x00\x00\x00\x20ftypM4A ", want: FormatM4A, ok: true},
+		{name: "mp3", header: "ID3\x04\x00\x00\x00\x00\x00\x00\x00\x00"},
+		{name: "short", header: "fLaC"},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name)
+		if err := os.WriteFile(path, []byte(tt.header), 0644); err != nil {
+			t.Fatal(err)
+		}
+		format, err := DetectFormat(path)
+		if format != tt.want || (err == nil) != tt.ok {
+			t.Errorf("DetectFormat(%s) = %q, %v; want %q", tt.name, format, err, tt.want)
+		}
+	}
+}